@@ -0,0 +1,102 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"9fans.net/go/acme"
+	"9fans.net/go/plan9/client"
+)
+
+var acmeAddr = flag.String("acme", "", "connect to acme at `addr`, a service name or a dial string like tcp!host!564")
+var namespace = flag.String("ns", "", "use `dir` as the plan9port name space directory instead of $NAMESPACE")
+
+// parseDial converts a Plan 9 dial string, or a bare service name
+// posted in the name space directory, into a Go network and address.
+func parseDial(s string) (network, addr string, err error) {
+	f := strings.Split(s, "!")
+	switch {
+	case len(f) == 1:
+		return "unix", filepath.Join(client.Namespace(), s), nil
+	case len(f) == 2 && f[0] == "unix":
+		return "unix", f[1], nil
+	case len(f) == 3 && f[0] != "unix":
+		return f[0], net.JoinHostPort(f[1], f[2]), nil
+	}
+	return "", "", fmt.Errorf("bad acme address %q", s)
+}
+
+// connectAcme connects the acme package to the server named by -acme.
+//
+// The acme package always dials $NAMESPACE/acme, so F listens on a
+// socket of that name in a private directory, points $NAMESPACE there
+// just long enough for the acme package to mount it, and forwards the
+// connection to the real server.
+//
+// With -ns, $NAMESPACE is set for the rest of F's life, so that the
+// window connection, the log reader, and the commands F runs all use
+// the same name space.
+func connectAcme() error {
+	if *namespace != "" {
+		os.Setenv("NAMESPACE", *namespace)
+	}
+	if *acmeAddr == "" {
+		return nil
+	}
+	network, addr, err := parseDial(*acmeAddr)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "F-acme")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	l, err := net.Listen("unix", filepath.Join(dir, "acme"))
+	if err != nil {
+		return err
+	}
+	go forward(l, network, addr)
+
+	old, had := os.LookupEnv("NAMESPACE")
+	os.Setenv("NAMESPACE", dir)
+	_, err = acme.Windows() // mounts acme
+	if had {
+		os.Setenv("NAMESPACE", old)
+	} else {
+		os.Unsetenv("NAMESPACE")
+	}
+	if err != nil {
+		return fmt.Errorf("connect to acme at %s: %w", *acmeAddr, err)
+	}
+	return nil
+}
+
+// forward copies each connection accepted on l to and from network!addr.
+func forward(l net.Listener, network, addr string) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			s, err := net.Dial(network, addr)
+			if err != nil {
+				return
+			}
+			defer s.Close()
+			go io.Copy(s, c)
+			io.Copy(c, s)
+		}()
+	}
+}
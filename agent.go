@@ -0,0 +1,395 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hherman1/F/watch"
+)
+
+var agentMode = flag.Bool("agent", false, "run without acme, watching files and serving the window to F -attach")
+var attachMode = flag.Bool("attach", false, "open a window on the agent running in the current directory")
+var agentSock = flag.String("socket", "", "with -agent or -attach, use the socket `file` instead of one in the state directory")
+
+// agentSocket returns the name of the socket of the agent for dir.
+func agentSocket(dir string) (string, error) {
+	if *agentSock != "" {
+		return *agentSock, nil
+	}
+	state := stateDir()
+	if state == "" {
+		return "", errors.New("no state directory for the agent socket; use -socket")
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(state, "agents", fmt.Sprintf("%x.sock", sum[:8])), nil
+}
+
+// An agentMsg is a message between an agent and an attached frontend,
+// sent as a line of JSON.
+//
+// The agent sends "state", with the window's Name, Tag, Body, and
+// Dirty, when a frontend attaches, and then each change to the window:
+// "addr" with Addr, "write" with File and Text, "ctl" with Text, and
+// "err" with Text. The frontend sends edits to the window, "tag" with
+// the new Text of the tag and "edit" replacing Q0 to Q1 of the body
+// with Text (or "body" with all of it, if the edit is too long for
+// acme to report), which the agent passes on to the other frontends,
+// and the commands executed in it, "exec" with Cmd
+// and Arg, and "toggle" with Q0 for a fold header executed in the body.
+type agentMsg struct {
+	Op    string `json:"op"`
+	Name  string `json:"name,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	Body  string `json:"body,omitempty"`
+	Dirty bool   `json:"dirty,omitempty"`
+	Addr  string `json:"addr,omitempty"`
+	File  string `json:"file,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Cmd   string `json:"cmd,omitempty"`
+	Arg   string `json:"arg,omitempty"`
+	Q0    int    `json:"q0,omitempty"`
+	Q1    int    `json:"q1,omitempty"`
+}
+
+// A memWin is the window of an agent: an in-memory copy of the
+// window, whose changes are passed on to the attached frontends.
+// Like an acme window's, its addresses count runes.
+//
+// Edits made in a frontend reach the agent after any changes the
+// agent made meanwhile, so a frontend edited during a run may be left
+// out of step until the next run rewrites the lines concerned.
+type memWin struct {
+	name string
+
+	mu      sync.Mutex
+	tag     string
+	body    []rune
+	q0, q1  int
+	dirty   bool
+	clients map[*agentClient]bool
+}
+
+// An agentClient is a frontend attached to an agent.
+type agentClient struct {
+	conn net.Conn
+	out  chan []byte
+}
+
+func newMemWin(name string) *memWin {
+	return &memWin{name: name, clients: make(map[*agentClient]bool)}
+}
+
+// send passes m to the attached frontends other than except.
+// A frontend too slow to keep up is detached.
+// w.mu must be held.
+func (w *memWin) send(m agentMsg, except *agentClient) {
+	if len(w.clients) == 0 {
+		return
+	}
+	js, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	js = append(js, '\n')
+	for c := range w.clients {
+		if c == except {
+			continue
+		}
+		select {
+		case c.out <- js:
+		default:
+			vlogf("agent: frontend too slow: detaching")
+			w.detach(c)
+		}
+	}
+}
+
+// detach removes c from the attached frontends. w.mu must be held.
+func (w *memWin) detach(c *agentClient) {
+	if w.clients[c] {
+		delete(w.clients, c)
+		close(c.out)
+	}
+}
+
+// addr sets the address to addr, as acme would. w.mu must be held.
+func (w *memWin) addr(addr string) error {
+	switch addr {
+	case "$":
+		w.q0, w.q1 = len(w.body), len(w.body)
+		return nil
+	case ",":
+		w.q0, w.q1 = 0, len(w.body)
+		return nil
+	}
+	a, b, ok := strings.Cut(addr, ",")
+	q0, err := strconv.Atoi(strings.TrimPrefix(a, "#"))
+	if err != nil || !strings.HasPrefix(a, "#") {
+		return fmt.Errorf("bad address %q", addr)
+	}
+	q1 := q0
+	if ok {
+		if q1, err = strconv.Atoi(strings.TrimPrefix(b, "#")); err != nil || !strings.HasPrefix(b, "#") {
+			return fmt.Errorf("bad address %q", addr)
+		}
+	}
+	if q0 < 0 || q1 < q0 || q1 > len(w.body) {
+		return fmt.Errorf("address %q out of range", addr)
+	}
+	w.q0, w.q1 = q0, q1
+	return nil
+}
+
+// replace replaces the text at the address with text, leaving the
+// address just after it. w.mu must be held.
+func (w *memWin) replace(text string) {
+	r := []rune(text)
+	body := make([]rune, 0, len(w.body)-(w.q1-w.q0)+len(r))
+	body = append(body, w.body[:w.q0]...)
+	body = append(body, r...)
+	w.body = append(body, w.body[w.q1:]...)
+	w.q0 += len(r)
+	w.q1 = w.q0
+}
+
+func (w *memWin) Addr(format string, args ...interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	addr := fmt.Sprintf(format, args...)
+	if err := w.addr(addr); err != nil {
+		return err
+	}
+	w.send(agentMsg{Op: "addr", Addr: addr}, nil)
+	return nil
+}
+
+func (w *memWin) Ctl(format string, args ...interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	msg := fmt.Sprintf(format, args...)
+	switch msg {
+	case "dirty":
+		w.dirty = true
+	case "clean":
+		w.dirty = false
+	case "cleartag":
+		w.tag = ""
+	}
+	w.send(agentMsg{Op: "ctl", Text: msg}, nil)
+	return nil
+}
+
+func (w *memWin) Fprintf(file, format string, args ...interface{}) error {
+	_, err := w.Write(file, []byte(fmt.Sprintf(format, args...)))
+	return err
+}
+
+func (w *memWin) ReadAll(file string) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch file {
+	case "tag":
+		return []byte(w.tag), nil
+	case "body":
+		return []byte(string(w.body)), nil
+	}
+	return nil, fmt.Errorf("agent window has no %s file", file)
+}
+
+func (w *memWin) Write(file string, b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch file {
+	case "tag":
+		w.tag += string(b)
+	case "data":
+		w.replace(string(b))
+	default:
+		return 0, fmt.Errorf("agent window has no %s file", file)
+	}
+	w.send(agentMsg{Op: "write", File: file, Text: string(b)}, nil)
+	return len(b), nil
+}
+
+func (w *memWin) Errf(format string, args ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	msg := fmt.Sprintf(format, args...)
+	if len(w.clients) == 0 {
+		log.Print(msg)
+		return
+	}
+	w.send(agentMsg{Op: "err", Text: msg}, nil)
+}
+
+// Selection returns "": an agent has no selection.
+// Frontends pass theirs as the argument to Only.
+func (w *memWin) Selection() string {
+	return ""
+}
+
+// serve attaches the frontends that connect to l to the window of rn.
+func (w *memWin) serve(l net.Listener, rn *Runner) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go w.attach(conn, rn)
+	}
+}
+
+// attach shows the window to the frontend on conn and
+// handles its messages until it disconnects.
+func (w *memWin) attach(conn net.Conn, rn *Runner) {
+	c := &agentClient{conn: conn, out: make(chan []byte, 1024)}
+	go func() {
+		bw := bufio.NewWriter(conn)
+		for js := range c.out {
+			bw.Write(js)
+			if len(c.out) == 0 && bw.Flush() != nil {
+				break
+			}
+		}
+		conn.Close()
+	}()
+
+	w.mu.Lock()
+	js, _ := json.Marshal(agentMsg{Op: "state", Name: w.name, Tag: w.tag, Body: string(w.body), Dirty: w.dirty})
+	c.out <- append(js, '\n')
+	w.clients[c] = true
+	w.mu.Unlock()
+	vlogf("agent: frontend attached")
+
+	s := bufio.NewScanner(conn)
+	s.Buffer(nil, maxScanLine+1<<20)
+	for s.Scan() {
+		var m agentMsg
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			vlogf("agent: bad message: %v", err)
+			continue
+		}
+		w.handle(c, m, rn)
+	}
+	w.mu.Lock()
+	w.detach(c)
+	w.mu.Unlock()
+	vlogf("agent: frontend detached")
+}
+
+// handle handles message m from frontend c.
+func (w *memWin) handle(c *agentClient, m agentMsg, rn *Runner) {
+	switch m.Op {
+	case "tag":
+		w.mu.Lock()
+		changed := m.Text != w.tag
+		if changed {
+			// Show the edit in the other frontends, whose tags
+			// then come back unchanged.
+			w.tag = m.Text
+			w.send(agentMsg{Op: "ctl", Text: "cleartag"}, c)
+			w.send(agentMsg{Op: "write", File: "tag", Text: m.Text}, c)
+		}
+		w.mu.Unlock()
+		if !changed {
+			break
+		}
+		if err := rn.tagEdited(); err != nil {
+			log.Print(err)
+		}
+	case "edit":
+		w.mu.Lock()
+		if w.addr(fmt.Sprintf("#%d,#%d", m.Q0, m.Q1)) == nil {
+			w.replace(m.Text)
+			w.send(agentMsg{Op: "addr", Addr: fmt.Sprintf("#%d,#%d", m.Q0, m.Q1)}, c)
+			w.send(agentMsg{Op: "write", File: "data", Text: m.Text}, c)
+		}
+		w.mu.Unlock()
+	case "body":
+		w.mu.Lock()
+		w.body = []rune(m.Text)
+		w.q0, w.q1 = 0, 0
+		w.send(agentMsg{Op: "addr", Addr: ","}, c)
+		w.send(agentMsg{Op: "write", File: "data", Text: m.Text}, c)
+		w.mu.Unlock()
+	case "exec":
+		rn.execute(m.Cmd, m.Arg)
+	case "toggle":
+		rn.toggle(m.Q0)
+	default:
+		vlogf("agent: unknown message %q", m.Op)
+	}
+}
+
+// listenAgent listens on the agent socket sock,
+// replacing it if it is left over from an agent that has exited.
+func listenAgent(sock string) (net.Listener, error) {
+	if c, err := net.Dial("unix", sock); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("an agent is already listening on %s", sock)
+	}
+	os.Remove(sock)
+	if err := os.MkdirAll(filepath.Dir(sock), 0700); err != nil {
+		return nil, err
+	}
+	return net.Listen("unix", sock)
+}
+
+// runAgent runs F as an agent, without acme, until it is interrupted.
+// The agent watches the file system, as with -fs, since it has no
+// acme log from which to learn of Puts.
+func runAgent() {
+	if *errorsMode {
+		log.Fatal("-agent cannot be used with -errors")
+	}
+	pwd, _ := os.Getwd()
+	sock, err := agentSocket(pwd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l, err := listenAgent(sock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := newMemWin(strings.TrimSuffix(pwd, "/") + "/+f")
+	w.tag = initialTag()
+	rn := newRunner(w)
+	rn.register(sock)
+	rn.listenControl()
+	eng := rn.eng
+	watchPower(eng)
+	if !*noRun {
+		eng.Kick(watch.Trigger{Kind: "start"})
+	}
+	go eng.Run()
+	*fsWatch = true
+	startWatchers(rn, pwd)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		l.Close()
+	}()
+	log.Printf("agent listening on %s", sock)
+	w.serve(l, rn)
+	rn.close()
+	rn.eng.Stop()
+	os.Exit(0)
+}
@@ -0,0 +1,291 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"9fans.net/go/acme"
+)
+
+// agentCommands are the commands in a window that an attached
+// frontend passes to its agent.
+var agentCommands = map[string]bool{
+	"Run": true, "Kill": true, "Quit": true, "Debug": true, "Stats": true,
+	"More": true, "Compare": true, "Only": true,
+}
+
+// A frontend shows the window of an agent in acme.
+//
+// Changes from the agent are made through the window's address,
+// so mu is held while they are made and while anything else
+// uses the address.
+type frontend struct {
+	win  *acme.Win
+	conn net.Conn
+	enc  *json.Encoder
+	mu   sync.Mutex
+}
+
+// runAttach shows the window of the agent for the current directory
+// in a new acme window, until the window or the agent goes away.
+func runAttach() {
+	pwd, _ := os.Getwd()
+	sock, err := agentSocket(pwd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	attachAgent(pwd, sock)
+}
+
+// runAttachDir shows the window of the F running in dir, for F attach
+// dir. The window of an F running in acme is shown where it is; that of
+// an agent is shown in the window already attached to it, if any, or
+// else in a new window, as with -attach.
+func runAttachDir(dir string) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	list, err := listInstances()
+	if err != nil {
+		log.Fatal(err)
+	}
+	inst := findInstance(list, dir)
+	if inst == nil {
+		log.Fatalf("attach: no F running in %s (see F list)", dir)
+	}
+	id := inst.Win
+	if inst.Agent {
+		id = findWin(strings.TrimSuffix(dir, "/") + "/+f")
+	}
+	if id > 0 {
+		w, err := acme.Open(id, nil)
+		if err != nil {
+			log.Fatalf("attach: window %d: %v", id, err)
+		}
+		w.Ctl("show")
+		os.Exit(0)
+	}
+	if !inst.Agent {
+		log.Fatalf("attach: F %d in %s has no window", inst.PID, dir)
+	}
+	sock := inst.Socket
+	if sock == "" {
+		if sock, err = agentSocket(dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+	attachAgent(dir, sock)
+}
+
+// findInstance returns the instance of F running in dir, preferring
+// one with an acme window to an agent, or nil if there is none.
+func findInstance(list []instance, dir string) *instance {
+	var found *instance
+	for i := range list {
+		inst := &list[i]
+		if inst.Dir == dir && (found == nil || found.Agent && !inst.Agent) {
+			found = inst
+		}
+	}
+	return found
+}
+
+// findWin returns the ID of the acme window named name, or 0 if there is none.
+func findWin(name string) int {
+	ws, err := acme.Windows()
+	if err != nil {
+		return 0
+	}
+	for _, w := range ws {
+		if w.Name == name {
+			return w.ID
+		}
+	}
+	return 0
+}
+
+// attachAgent shows the window of the agent for dir, listening on sock,
+// in a new acme window, until the window or the agent goes away.
+func attachAgent(dir, sock string) {
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		log.Fatalf("attach: no agent for %s: %v", dir, err)
+	}
+	s := bufio.NewScanner(conn)
+	s.Buffer(nil, 1<<30)
+	var state agentMsg
+	if !s.Scan() || json.Unmarshal(s.Bytes(), &state) != nil || state.Op != "state" {
+		log.Fatalf("attach: bad greeting from agent on %s", sock)
+	}
+	w, err := acme.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fe := &frontend{win: w, conn: conn, enc: json.NewEncoder(conn)}
+	fe.show(state)
+	go fe.events()
+	for s.Scan() {
+		var m agentMsg
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			continue
+		}
+		fe.mu.Lock()
+		fe.apply(m)
+		fe.mu.Unlock()
+	}
+	w.Ctl("clean")
+	w.Errf("agent on %s has gone away: %v", sock, s.Err())
+	os.Exit(1)
+}
+
+// show replaces the window's contents with the agent's window, state.
+func (fe *frontend) show(state agentMsg) {
+	w := fe.win
+	w.Name(state.Name)
+	w.Ctl("cleartag")
+	w.Fprintf("tag", "%s", userTag(state.Tag))
+	w.Addr(",")
+	w.Write("data", []byte(state.Body))
+	if state.Dirty {
+		w.Ctl("dirty")
+	} else {
+		w.Ctl("clean")
+	}
+}
+
+// userTag returns the part of tag after the | that ends the part
+// acme maintains, or all of tag if there is no |.
+func userTag(tag string) string {
+	if _, after, ok := strings.Cut(tag, "|"); ok {
+		return strings.TrimLeft(after, " ")
+	}
+	return tag
+}
+
+// apply makes the change to the agent's window described by m.
+func (fe *frontend) apply(m agentMsg) {
+	w := fe.win
+	switch m.Op {
+	case "addr":
+		w.Addr("%s", m.Addr)
+	case "write":
+		if m.File == "tag" {
+			w.Fprintf("tag", "%s", m.Text)
+		} else {
+			w.Write("data", []byte(m.Text))
+		}
+	case "ctl":
+		w.Ctl("%s", m.Text)
+	case "err":
+		w.Errf("%s", m.Text)
+	case "state":
+		fe.show(m)
+	}
+}
+
+// send passes m to the agent.
+func (fe *frontend) send(m agentMsg) {
+	if err := fe.enc.Encode(m); err != nil {
+		vlogf("attach: %v", err)
+	}
+}
+
+// events passes edits and commands in the window to the agent,
+// and closes the connection, which ends runAttach, once the window
+// is deleted.
+func (fe *frontend) events() {
+	w := fe.win
+	for e := range w.EventChan() {
+		switch {
+		case e.C2 == 'i' || e.C2 == 'd':
+			if tag, err := w.ReadAll("tag"); err == nil {
+				fe.send(agentMsg{Op: "tag", Text: userTag(string(tag))})
+			}
+			continue
+		case (e.C1 == 'K' || e.C1 == 'M') && (e.C2 == 'I' || e.C2 == 'D'):
+			fe.edited(e)
+			continue
+		case e.C2 == 'X' && fe.isHeader(e.Q0):
+			fe.send(agentMsg{Op: "toggle", Q0: e.Q0})
+			continue
+		case e.C2 == 'x' || e.C2 == 'X':
+			text := strings.TrimSpace(string(e.Text))
+			name, _, _ := strings.Cut(text, " ")
+			if agentCommands[name] {
+				arg := strings.TrimSpace(string(e.Arg))
+				if name == "Only" && arg == "" && text == name {
+					fe.keepAddr(func() { arg = strings.TrimSpace(w.Selection()) })
+				}
+				fe.send(agentMsg{Op: "exec", Cmd: text, Arg: arg})
+				continue
+			}
+			if text == "Del" {
+				w.Ctl("delete")
+			}
+		}
+		w.WriteEvent(e)
+	}
+	fe.conn.Close()
+	os.Exit(0)
+}
+
+// edited passes the body edit e to the agent, or the whole body if
+// acme left out the inserted text for being too long.
+func (fe *frontend) edited(e *acme.Event) {
+	if e.C2 == 'D' {
+		fe.send(agentMsg{Op: "edit", Q0: e.Q0, Q1: e.Q1})
+		return
+	}
+	if utf8.RuneCount(e.Text) == e.Q1-e.Q0 {
+		fe.send(agentMsg{Op: "edit", Q0: e.Q0, Q1: e.Q0, Text: string(e.Text)})
+		return
+	}
+	body, err := fe.win.ReadAll("body")
+	if err != nil {
+		return
+	}
+	fe.send(agentMsg{Op: "body", Text: string(body)})
+}
+
+// keepAddr calls f, which may set the window's address,
+// and then puts the address back as it was.
+func (fe *frontend) keepAddr(f func()) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	q0, q1, err := fe.win.ReadAddr()
+	f()
+	if err == nil {
+		fe.win.Addr("#%d,#%d", q0, q1)
+	}
+}
+
+// isHeader reports whether the body line at rune offset q
+// begins with a glyph marking a -fold header.
+func (fe *frontend) isHeader(q int) bool {
+	var line string
+	fe.keepAddr(func() {
+		if fe.win.Addr("#%d+-", q) != nil {
+			return
+		}
+		b := make([]byte, len(closedGlyph))
+		if n, err := fe.win.Read("xdata", b); err == nil {
+			line = string(b[:n])
+		}
+	})
+	return strings.HasPrefix(line, closedGlyph) || strings.HasPrefix(line, openGlyph)
+}
@@ -0,0 +1,46 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+var cmdFile = flag.String("cmdfile", "", "read the command from `file`, rereading it for each run, instead of the tag")
+
+// readCmdFile returns the command in the -cmdfile file.
+// It may span several lines; rc runs them in turn.
+func readCmdFile() (string, error) {
+	b, err := os.ReadFile(*cmdFile)
+	if err != nil {
+		return "", fmt.Errorf("read command: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// watchCmdFile calls kick each time the command file name changes.
+// Like watchStaged, it polls the file's modification time, so that
+// edits made in any editor, in any directory, are picked up.
+func watchCmdFile(name string, kick func(watch.Trigger)) {
+	var last time.Time
+	if info, err := os.Stat(name); err == nil {
+		last = info.ModTime()
+	}
+	for {
+		time.Sleep(500 * time.Millisecond)
+		info, err := os.Stat(name)
+		if err != nil || info.ModTime().Equal(last) {
+			continue
+		}
+		last = info.ModTime()
+		kick(watch.Trigger{Kind: "cmdfile", File: name})
+	}
+}
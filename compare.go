@@ -0,0 +1,166 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+// maxCompare bounds the output held from the two commands of a comparison.
+const maxCompare = 4 << 20
+
+// maxDiffCells bounds the work done to find the lines two outputs share.
+// Beyond it, all the lines between their common prefix and suffix
+// are shown as changed.
+const maxDiffCells = 4 << 20
+
+// A comparison is a run of the command in the tag, a, followed by
+// another, b, whose outputs are shown as a diff. The two commands run
+// in one shell, with a marker line printed between them that splits
+// the combined output.
+type comparison struct {
+	a, b   string
+	marker string
+	out    []byte
+	full   bool // output exceeded maxCompare
+}
+
+// setCompare makes each later run compare the command in the tag
+// with b, or, if b is empty, stops comparing, and triggers a run.
+func (rn *Runner) setCompare(b string) {
+	rn.mu.Lock()
+	rn.compare = b
+	rn.mu.Unlock()
+	if b == "" {
+		vlogf("compare: off")
+	} else {
+		vlogf("compare: with %s", b)
+	}
+	rn.eng.Kick(watch.Trigger{Kind: "run"})
+}
+
+// prepareCompare rewrites the command of run r to compare it with
+// the command given to Compare, if any.
+func (rn *Runner) prepareCompare(r *watch.Run) {
+	rn.mu.Lock()
+	b := rn.compare
+	rn.mu.Unlock()
+	if b != "" {
+		c := &comparison{a: r.Line, b: b, marker: fmt.Sprintf("F-compare-%d-%d", r.ID, time.Now().UnixNano())}
+		r.Line = c.a + "\necho " + c.marker + "\n" + c.b
+		rn.mu.Lock()
+		rn.comparing[r.ID] = c
+		rn.mu.Unlock()
+	}
+}
+
+// write adds p to the combined output of the two commands.
+func (c *comparison) write(p []byte) {
+	if n := maxCompare - len(c.out); len(p) > n {
+		p = p[:n]
+		c.full = true
+	}
+	c.out = append(c.out, p...)
+}
+
+// show writes the diff of the two commands' outputs to out.
+func (c *comparison) show(out *view) {
+	a, b, ok := strings.Cut(string(c.out), c.marker+"\n")
+	if !ok {
+		out.printf("%s", a)
+		if a != "" && !strings.HasSuffix(a, "\n") {
+			out.printf("\\\n")
+		}
+		out.printf("(compare: %s did not finish)\n", c.a)
+		return
+	}
+	if a == b {
+		out.printf("no differences (%d lines)\n", strings.Count(a, "\n"))
+	} else {
+		out.printf("--- %s\n+++ %s\n", c.a, c.b)
+		for _, l := range lineDiff(splitLines(a), splitLines(b)) {
+			out.printf("%s\n", l)
+		}
+	}
+	if c.full {
+		out.printf("(compare: output truncated at %d bytes)\n", maxCompare)
+	}
+}
+
+// splitLines splits s into lines without their newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineDiff returns the lines of a and b as a diff: lines in both
+// prefixed by a space, lines only in a by -, and lines only in b by +.
+func lineDiff(a, b []string) []string {
+	var pre, suf []string
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		pre = append(pre, " "+a[0])
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suf = append(suf, " "+a[len(a)-1])
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	d := pre
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			d = append(d, "-"+l)
+		}
+		for _, l := range b {
+			d = append(d, "+"+l)
+		}
+	} else {
+		d = append(d, lcsDiff(a, b)...)
+	}
+	for i := len(suf) - 1; i >= 0; i-- {
+		d = append(d, suf[i])
+	}
+	return d
+}
+
+// lcsDiff is lineDiff by way of a longest common subsequence.
+func lcsDiff(a, b []string) []string {
+	// n[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	n := make([][]int, len(a)+1)
+	for i := range n {
+		n[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				n[i][j] = n[i+1][j+1] + 1
+			} else {
+				n[i][j] = max(n[i+1][j], n[i][j+1])
+			}
+		}
+	}
+	var d []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			d = append(d, " "+a[i])
+			i++
+			j++
+		case j == len(b) || i < len(a) && n[i+1][j] >= n[i][j+1]:
+			d = append(d, "-"+a[i])
+			i++
+		default:
+			d = append(d, "+"+b[j])
+			j++
+		}
+	}
+	return d
+}
@@ -0,0 +1,243 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var configFlag = flag.String("config", "", "read flags from `file`, rereading it when it changes (default $XDG_CONFIG_HOME/F/flags, if it exists)")
+
+// reloadable lists the flags that a changed config file can change
+// while F runs. They are read only on the engine's frontend goroutine,
+// where reloadConfig sets them, so that no run sees one change under
+// it, except for -shell, which reaches the engine by SetShell, and the
+// filters read by the watchers, which filterMu guards.
+var reloadable = map[string]bool{
+	"dot": true, "encoding": true, "fold": true, "hang": true,
+	"hangkill": true, "match": true, "no-default-ignores": true,
+	"quickfix": true, "shell": true, "slow": true, "toolwins": true,
+	"webhook": true, "wps": true,
+}
+
+// filterMu guards -toolwins and -no-default-ignores.
+var filterMu sync.RWMutex
+
+// cmdlineFlags holds the flags set on the command line,
+// which override the config file.
+var cmdlineFlags = make(map[string]bool)
+
+// configSet holds the flags set from the config file, by name.
+var configSet = make(map[string]string)
+
+// configFile returns the name of the config file, or "" if there is none.
+func configFile() string {
+	if *configFlag != "" {
+		return *configFlag
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	name := filepath.Join(dir, "F", "flags")
+	if _, err := os.Stat(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// parseConfig returns the flag settings in the config file text, by
+// name. Each line holds one flag, as -name, -name=value, or -name value,
+// with the leading - optional and the value running to the end of the
+// line unquoted; blank lines and lines beginning with # are ignored.
+func parseConfig(text string) (map[string]string, error) {
+	set := make(map[string]string)
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimLeft(line, "-")
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			name, value, ok = strings.Cut(line, " ")
+			value = strings.TrimSpace(value)
+		}
+		f := flag.Lookup(name)
+		if f == nil {
+			return nil, fmt.Errorf("line %d: no flag -%s", i+1, name)
+		}
+		if !ok {
+			if b, isBool := f.Value.(interface{ IsBoolFlag() bool }); !isBool || !b.IsBoolFlag() {
+				return nil, fmt.Errorf("line %d: -%s needs a value", i+1, name)
+			}
+			value = "true"
+		}
+		set[name] = value
+	}
+	return set, nil
+}
+
+// loadConfig sets the flags in the config file, other than those set
+// on the command line. It must be called just after flag.Parse.
+func loadConfig() error {
+	flag.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+	name := configFile()
+	if name == "" {
+		return nil
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	set, err := parseConfig(string(b))
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	for n, v := range set {
+		if cmdlineFlags[n] {
+			continue
+		}
+		if err := flag.Set(n, v); err != nil {
+			return fmt.Errorf("%s: -%s: %v", name, n, err)
+		}
+		configSet[n] = v
+	}
+	return nil
+}
+
+// checkFlags checks the flags whose values F prepares before use.
+func checkFlags() error {
+	if err := checkDot(); err != nil {
+		return err
+	}
+	if err := checkOrder(); err != nil {
+		return err
+	}
+	if err := setEncoding(); err != nil {
+		return err
+	}
+	return compileMatch()
+}
+
+// watchConfig rereads the config file each time it changes, polling
+// its modification time as watchCmdFile does, and reloads it into rn.
+func watchConfig(rn *Runner) {
+	name := configFile()
+	if name == "" {
+		return
+	}
+	var last time.Time
+	if info, err := os.Stat(name); err == nil {
+		last = info.ModTime()
+	}
+	for {
+		time.Sleep(500 * time.Millisecond)
+		info, err := os.Stat(name)
+		if err != nil || info.ModTime().Equal(last) {
+			continue
+		}
+		last = info.ModTime()
+		b, err := os.ReadFile(name)
+		if err != nil {
+			rn.report("config: %v", err)
+			continue
+		}
+		set, err := parseConfig(string(b))
+		if err != nil {
+			rn.report("%s: %v; not reloaded", name, err)
+			continue
+		}
+		done := make(chan bool)
+		rn.eng.Post(nil, func() {
+			rn.reloadConfig(name, set)
+			close(done)
+		})
+		<-done
+	}
+}
+
+// reloadConfig applies the flag settings set, newly read from the
+// config file name, announcing the changes in the window. Flags no
+// longer in the file go back to their defaults. Settings that cannot
+// change while F runs, and flags set on the command line, are left as
+// they are. If the new settings are not valid, none are applied.
+// It must be called from the engine's frontend goroutine.
+func (rn *Runner) reloadConfig(name string, set map[string]string) {
+	filterMu.Lock()
+	defer filterMu.Unlock()
+	want := make(map[string]string)
+	for n := range configSet {
+		want[n] = flag.Lookup(n).DefValue
+	}
+	for n, v := range set {
+		want[n] = v
+	}
+	var names []string
+	for n := range want {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	old := make(map[string]string)
+	var changed, fixed, err []string
+	for _, n := range names {
+		f := flag.Lookup(n)
+		if cmdlineFlags[n] || f.Value.String() == want[n] {
+			continue
+		}
+		if !reloadable[n] {
+			fixed = append(fixed, "-"+n)
+			continue
+		}
+		old[n] = f.Value.String()
+		if e := f.Value.Set(want[n]); e != nil {
+			err = append(err, fmt.Sprintf("-%s: %v", n, e))
+			continue
+		}
+		changed = append(changed, fmt.Sprintf("-%s=%s", n, want[n]))
+	}
+	if e := checkFlags(); e != nil {
+		err = append(err, e.Error())
+	}
+	if len(err) > 0 {
+		for n, v := range old {
+			flag.Set(n, v)
+		}
+		checkFlags()
+		rn.report("%s: %s; not reloaded", name, strings.Join(err, "; "))
+		return
+	}
+	for _, n := range names {
+		if cmdlineFlags[n] || !reloadable[n] {
+			continue
+		}
+		if v, ok := set[n]; ok {
+			configSet[n] = v
+		} else {
+			delete(configSet, n)
+		}
+	}
+	if _, ok := old["shell"]; ok {
+		rn.eng.SetShell(shell())
+	}
+	var msg []string
+	if len(changed) > 0 {
+		msg = append(msg, "reloaded "+strings.Join(changed, " "))
+	}
+	if len(fixed) > 0 {
+		msg = append(msg, "restart F to change "+strings.Join(fixed, " "))
+	}
+	if len(msg) > 0 {
+		rn.report("%s: %s", name, strings.Join(msg, "; "))
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+// listenControl starts rn's control socket, through which other
+// programs can drive it with F ctl, and records it in rn's instance
+// file. It does nothing if rn has no instance file.
+func (rn *Runner) listenControl() {
+	if rn.inst == nil {
+		return
+	}
+	sock := filepath.Join(stateDir(), "control", fmt.Sprintf("%d.sock", os.Getpid()))
+	if err := os.MkdirAll(filepath.Dir(sock), 0700); err != nil {
+		vlogf("control: %v", err)
+		return
+	}
+	os.Remove(sock)
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		vlogf("control: %v", err)
+		return
+	}
+	rn.inst.Control = sock
+	rn.inst.control = l
+	rn.saveInstance()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go rn.serveControl(c)
+		}
+	}()
+}
+
+// serveControl carries out the control requests on c, one per line,
+// answering each with "ok", lines of status, or "error: " and why.
+func (rn *Runner) serveControl(c net.Conn) {
+	defer c.Close()
+	s := bufio.NewScanner(c)
+	for s.Scan() {
+		reply, err := rn.control(s.Text())
+		if err != nil {
+			reply = "error: " + err.Error() + "\n"
+		}
+		if _, err := io.WriteString(c, reply); err != nil {
+			return
+		}
+	}
+}
+
+// control carries out the control request req, returning its reply.
+func (rn *Runner) control(req string) (string, error) {
+	verb, arg, _ := strings.Cut(strings.TrimSpace(req), " ")
+	arg = strings.TrimSpace(arg)
+	switch verb {
+	case "run":
+		rn.eng.Kick(watch.Trigger{Kind: "ctl", Arg: arg})
+	case "kill":
+		rn.eng.Kill()
+	case "set":
+		what, cmd, _ := strings.Cut(arg, " ")
+		if what != "cmd" {
+			return "", fmt.Errorf("cannot set %q: want set cmd command", what)
+		}
+		if err := rn.setCmd(strings.TrimSpace(cmd)); err != nil {
+			return "", err
+		}
+	case "status":
+		return rn.status(), nil
+	default:
+		return "", fmt.Errorf("unknown request %q: want run, set cmd, kill, or status", verb)
+	}
+	return "ok\n", nil
+}
+
+// setCmd replaces the first command in the window's tag with cmd,
+// which runs it, as if it had been typed there.
+func (rn *Runner) setCmd(cmd string) error {
+	if *cmdFile != "" {
+		return fmt.Errorf("the command comes from %s", *cmdFile)
+	}
+	if cmd == "" || strings.Contains(cmd, "\n") {
+		return errors.New("set cmd needs a one-line command")
+	}
+	tag, err := rn.win.ReadAll("tag")
+	if err != nil {
+		return err
+	}
+	user := userTag(string(tag))
+	i := strings.Index(user, "%")
+	if i < 0 {
+		user += " % " + cmd
+	} else {
+		_, after := cutCmd(user[i+1:])
+		user = user[:i] + "% " + cmd
+		if after != "" {
+			user += "\n" + after
+		}
+	}
+	if err := rn.win.Ctl("cleartag"); err != nil {
+		return err
+	}
+	if err := rn.win.Fprintf("tag", "%s", user); err != nil {
+		return err
+	}
+	return rn.tagEdited()
+}
+
+// status describes rn for the status request, one "name value" per line.
+func (rn *Runner) status() string {
+	running := !rn.idle()
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	pwd, _ := os.Getwd()
+	var b strings.Builder
+	fmt.Fprintf(&b, "pid %d\n", os.Getpid())
+	fmt.Fprintf(&b, "dir %s\n", pwd)
+	fmt.Fprintf(&b, "cmd %s\n", oneLine(rn.cmd))
+	fmt.Fprintf(&b, "running %v\n", running)
+	if n := len(rn.hist); n > 0 {
+		last := rn.hist[n-1]
+		fmt.Fprintf(&b, "last %s\n", last.status())
+		fmt.Fprintf(&b, "lastcmd %s\n", oneLine(last.Command))
+		fmt.Fprintf(&b, "lastend %s\n", last.Start.Add(time.Duration(last.Duration*float64(time.Second))).Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "runs %d\n", len(rn.runs))
+	return b.String()
+}
+
+// runCtl sends the request in args to the F running in the current
+// directory, for F ctl, printing the reply to out, and returns the
+// exit status for F.
+func runCtl(out io.Writer, args []string) int {
+	pwd, _ := os.Getwd()
+	list, err := listInstances()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "F: %v\n", err)
+		return 1
+	}
+	inst := findInstance(list, pwd)
+	if inst == nil || inst.Control == "" {
+		fmt.Fprintf(os.Stderr, "F: ctl: no F running in %s (see F list)\n", pwd)
+		return 1
+	}
+	c, err := net.Dial("unix", inst.Control)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "F: ctl: %v\n", err)
+		return 1
+	}
+	defer c.Close()
+	if _, err := fmt.Fprintf(c, "%s\n", strings.Join(args, " ")); err != nil {
+		fmt.Fprintf(os.Stderr, "F: ctl: %v\n", err)
+		return 1
+	}
+	c.(*net.UnixConn).CloseWrite()
+	b, err := io.ReadAll(c)
+	out.Write(b)
+	if err != nil || strings.HasPrefix(string(b), "error: ") {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+var daemon = flag.Bool("daemon", false, "keep watching and running the command while acme is gone, and reopen the window when it returns")
+var webhook = flag.String("webhook", "", "post a JSON record of each completed run to `url`")
+
+// unattended keeps rn watching pwd and running its command after acme
+// has exited, for -daemon, and once acme is back and no run is in
+// progress, reexecutes F to reopen the window. With acme gone, Puts
+// cannot be seen, so the file system is watched as with -fs, and the
+// results of runs, kept in the history as always, are logged.
+// Unattended never returns.
+func unattended(rn *Runner, pwd string) {
+	restartOnce.Do(func() {
+		log.Print("acme has exited; watching unattended until it restarts")
+		rn.mu.Lock()
+		rn.unattended = true
+		rn.mu.Unlock()
+		if !*fsWatch {
+			watchFiles(rn, pwd)
+		}
+		waitAcme()
+		for !rn.idle() {
+			time.Sleep(time.Second)
+		}
+		log.Print("acme has restarted; reopening the window")
+		rn.close()
+		reexecLine(rn.eng)
+	})
+	select {}
+}
+
+// idle reports whether rn has no run in progress.
+func (rn *Runner) idle() bool {
+	done := make(chan bool, 1)
+	rn.eng.Post(nil, func() { done <- len(rn.states) == 0 })
+	select {
+	case idle := <-done:
+		return idle
+	case <-rn.eng.Done():
+		return true
+	}
+}
+
+// ended reports the completed run recorded by rec: to the -webhook,
+// and in the log if F is running unattended.
+func (rn *Runner) ended(rec *record) {
+	rn.mu.Lock()
+	quiet := !rn.unattended
+	rn.mu.Unlock()
+	if !quiet {
+		log.Printf("%s: %s", oneLine(rec.Command), rec.status())
+	}
+	if *webhook != "" {
+		go postRun(*webhook, rec)
+	}
+}
+
+// webhookClient is the client used to post to the -webhook.
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// postRun posts rec, with the directory of the run, to url.
+func postRun(url string, rec *record) {
+	pwd, _ := os.Getwd()
+	js, err := json.Marshal(struct {
+		Dir string `json:"dir"`
+		*record
+	}{pwd, rec})
+	if err != nil {
+		return
+	}
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(js))
+	if err != nil {
+		vlogf("webhook: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		vlogf("webhook: %s", resp.Status)
+	}
+}
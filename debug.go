@@ -0,0 +1,89 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hherman1/F/watch"
+)
+
+// A testFailure is a failed Go test found in a run's output.
+type testFailure struct {
+	Pkg  string // import path
+	Test string // top-level test name
+}
+
+var (
+	failTestRE = regexp.MustCompile(`^\s*--- FAIL: (\S+)`)
+	failPkgRE  = regexp.MustCompile(`^FAIL\s+(\S+)`)
+)
+
+// A failScanner collects the Go test failures in output lines.
+// go test prints the failed tests of a package before the package's
+// FAIL line, so names are held until the package is known.
+type failScanner struct {
+	tests []string
+	fails []testFailure
+}
+
+func (s *failScanner) line(line string) {
+	if m := failTestRE.FindStringSubmatch(line); m != nil {
+		name, _, _ := strings.Cut(m[1], "/")
+		for _, t := range s.tests {
+			if t == name {
+				return
+			}
+		}
+		if len(s.tests) < maxDiags {
+			s.tests = append(s.tests, name)
+		}
+		return
+	}
+	if m := failPkgRE.FindStringSubmatch(line); m != nil {
+		for _, t := range s.tests {
+			if len(s.fails) < maxDiags {
+				s.fails = append(s.fails, testFailure{Pkg: m[1], Test: t})
+			}
+		}
+		s.tests = nil
+	}
+}
+
+// debug opens a win window running the failed test named arg,
+// or the first failed test if arg is empty, under dlv test.
+func (rn *Runner) debug(arg string) {
+	rn.mu.Lock()
+	fails := rn.fails
+	rn.mu.Unlock()
+	if len(fails) == 0 {
+		rn.win.Errf("no failed tests to debug")
+		return
+	}
+	f := fails[0]
+	if arg != "" {
+		found := false
+		for _, ff := range fails {
+			if ff.Test == arg {
+				f, found = ff, true
+				break
+			}
+		}
+		if !found {
+			rn.win.Errf("%s did not fail in the last run", arg)
+			return
+		}
+	}
+	wincmd := filepath.Join(filepath.Dir(watch.RC()), "win")
+	cmd := exec.Command(wincmd, "dlv", "test", f.Pkg, "--", "-test.run", "^"+regexp.QuoteMeta(f.Test)+"$")
+	if err := cmd.Start(); err != nil {
+		rn.win.Errf("debug: %v", err)
+		return
+	}
+	go cmd.Wait()
+}
@@ -0,0 +1,68 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var quickfixFile = flag.String("quickfix", "", "after each run, write error locations to `file` in file:line:col: msg form")
+
+// A diag is an error location found in a run's output.
+type diag struct {
+	File string
+	Line string
+	Col  string // may be empty
+	Msg  string
+}
+
+var diagRE = regexp.MustCompile(`^\s*([^\s:]+\.\w+):(\d+)(?::(\d+))?:\s*(.*)$`)
+
+// A diagScanner collects the error locations in output lines.
+type diagScanner struct {
+	diags []diag
+}
+
+func (s *diagScanner) line(line string) {
+	if len(s.diags) >= maxDiags {
+		return
+	}
+	if m := diagRE.FindStringSubmatch(line); m != nil {
+		s.diags = append(s.diags, diag{m[1], m[2], m[3], m[4]})
+	}
+}
+
+// writeQuickfix replaces the -quickfix file, if any, with diags.
+// File names are made absolute, so that the file can be read from
+// any directory. The file is written under a temporary name and
+// renamed into place, so readers never see a partial list.
+func writeQuickfix(diags []diag) {
+	if *quickfixFile == "" {
+		return
+	}
+	pwd, _ := os.Getwd()
+	var buf bytes.Buffer
+	for _, d := range diags {
+		file := d.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(pwd, file)
+		}
+		if d.Col != "" {
+			fmt.Fprintf(&buf, "%s:%s:%s: %s\n", file, d.Line, d.Col, d.Msg)
+		} else {
+			fmt.Fprintf(&buf, "%s:%s: %s\n", file, d.Line, d.Msg)
+		}
+	}
+	tmp := *quickfixFile + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0666); err != nil {
+		return
+	}
+	os.Rename(tmp, *quickfixFile)
+}
@@ -0,0 +1,95 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"time"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+var speculate = flag.Bool("speculate", false, "start a run as soon as a window in the directory is modified, ahead of its Put")
+var cancelOnEdit = flag.Bool("cancel-on-edit", false, "kill the current run as soon as a window in the directory is modified")
+
+// dirtyPoll is how often acme's index is read for modified windows.
+const dirtyPoll = 250 * time.Millisecond
+
+// mountAcme returns a connection to acme's file system, separate from
+// the acme package's, for reading its index, which the package does
+// not fully expose.
+func mountAcme() (*client.Fsys, error) {
+	if *acmeAddr == "" {
+		return client.MountService("acme")
+	}
+	network, addr, err := parseDial(*acmeAddr)
+	if err != nil {
+		return nil, err
+	}
+	return client.Mount(network, addr)
+}
+
+// watchDirty calls dirtied with the name of each window of a file in
+// pwd (as for Put) that goes from clean to modified. It polls acme's
+// index in the background, returning an error only if it cannot
+// connect to acme.
+func watchDirty(pwd, pwdSlash string, dirtied func(name string)) error {
+	fsys, err := mountAcme()
+	if err != nil {
+		return err
+	}
+	go func() {
+		// Windows already modified when F starts were not dirtied
+		// since, so the first reading of the index only seeds was.
+		var was map[string]bool
+		for {
+			dirty, err := readDirty(fsys)
+			if err != nil {
+				vlogf("acme index: %v", err)
+				time.Sleep(dirtyPoll)
+				continue
+			}
+			for name := range dirty {
+				if was != nil && !was[name] && inDir(name, pwd, pwdSlash) && !isToolWindow(name) {
+					dirtied(name)
+				}
+			}
+			was = dirty
+			time.Sleep(dirtyPoll)
+		}
+	}()
+	return nil
+}
+
+// readDirty returns the set of names of modified windows.
+func readDirty(fsys *client.Fsys) (map[string]bool, error) {
+	f, err := fsys.Open("index", plan9.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return parseIndex(string(data)), nil
+}
+
+// parseIndex returns the set of names of the modified windows
+// listed in index, the text of acme's index file.
+func parseIndex(index string) map[string]bool {
+	dirty := make(map[string]bool)
+	for _, line := range strings.Split(index, "\n") {
+		// id taglen bodylen isdir isdirty tag...
+		fields := strings.Fields(line)
+		if len(fields) >= 6 && fields[4] == "1" {
+			dirty[fields[5]] = true
+		}
+	}
+	return dirty
+}
@@ -0,0 +1,130 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"9fans.net/go/acme"
+	"9fans.net/go/plan9/client"
+	"github.com/hherman1/F/watch"
+)
+
+// A check is one of the checks made by F doctor.
+type check struct {
+	name string
+	run  func() (result, fix string, ok bool)
+}
+
+var doctorChecks = []check{
+	{"rc", checkRC},
+	{"name space", checkNamespace},
+	{"acme", checkAcme},
+	{"state directory", checkStateDir},
+	{"file watching", checkWatchLimit},
+}
+
+// runDoctor makes each check, printing the results to out,
+// and returns the exit status for F: 1 if any check failed.
+func runDoctor(out io.Writer) int {
+	status := 0
+	for _, c := range doctorChecks {
+		result, fix, ok := c.run()
+		if ok {
+			fmt.Fprintf(out, "ok    %s: %s\n", c.name, result)
+			continue
+		}
+		status = 1
+		fmt.Fprintf(out, "FAIL  %s: %s\n", c.name, result)
+		if fix != "" {
+			fmt.Fprintf(out, "      %s\n", fix)
+		}
+	}
+	return status
+}
+
+func checkRC() (string, string, bool) {
+	rc := watch.RC()
+	if _, err := exec.LookPath(rc); err != nil {
+		return fmt.Sprintf("no rc at %s", rc), "install plan9port and set $PLAN9 to its directory, or put its bin directory on $PATH", false
+	}
+	return rc, "", true
+}
+
+func checkNamespace() (string, string, bool) {
+	if *acmeAddr != "" {
+		return "not needed with -acme " + *acmeAddr, "", true
+	}
+	ns := *namespace
+	if ns == "" {
+		ns = client.Namespace()
+	}
+	if ns == "" {
+		return "no name space directory", "set $NAMESPACE, or $USER and $DISPLAY, as plan9port expects", false
+	}
+	if _, err := os.Stat(ns); err != nil {
+		return fmt.Sprintf("%s: %v", ns, err), "start acme (or plan9port's plumber or factotum) to create it, or set $NAMESPACE to the directory acme uses", false
+	}
+	if _, err := os.Stat(filepath.Join(ns, "acme")); err != nil {
+		return fmt.Sprintf("%s has no acme service", ns), "start acme, or set $NAMESPACE to the directory of the acme you want F to use", false
+	}
+	return ns, "", true
+}
+
+func checkAcme() (string, string, bool) {
+	if err := connectAcme(); err != nil {
+		return err.Error(), "check -acme and -ns", false
+	}
+	ws, err := acme.Windows()
+	if err != nil {
+		return fmt.Sprintf("cannot mount acme: %v", err), "start acme, and make sure F sees the same $NAMESPACE as acme", false
+	}
+	return fmt.Sprintf("connected; %d windows", len(ws)), "", true
+}
+
+func checkStateDir() (string, string, bool) {
+	dir := stateDir()
+	if dir == "" {
+		return "none; history is not kept", "", true
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err.Error(), "make the directory writable, or choose another with -state", false
+	}
+	f, err := os.CreateTemp(dir, "doctor")
+	if err != nil {
+		return err.Error(), "make the directory writable, or choose another with -state", false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return dir + " is writable", "", true
+}
+
+func checkWatchLimit() (string, string, bool) {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return err.Error(), "", false
+	}
+	dirs := make(map[string]bool)
+	watch.Walk(pwd, true, func(name string, info fs.FileInfo) {
+		dirs[filepath.Dir(name)] = true
+	})
+	limit, err := watchLimit()
+	if err != nil {
+		return fmt.Sprintf("%d directories under %s; %v", len(dirs), pwd, err), "", true
+	}
+	if limit < 0 {
+		return fmt.Sprintf("%d directories under %s; no limit known", len(dirs), pwd), "", true
+	}
+	result := fmt.Sprintf("%d directories under %s; the system allows %d watches", len(dirs), pwd, limit)
+	if len(dirs) > limit {
+		return result, "F -fs -r will poll the directories beyond the limit; raise it with sysctl fs.inotify.max_user_watches=524288", false
+	}
+	return result, "", true
+}
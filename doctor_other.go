@@ -0,0 +1,12 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package main
+
+// watchLimit returns -1: F polls, and there is no limit on watches.
+func watchLimit() (int, error) {
+	return -1, nil
+}
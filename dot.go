@@ -0,0 +1,65 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var dotPlace = flag.String("dot", "keep", "after each run, `place` dot and scroll the window to the top, bottom, or error (the first error) of the output, or keep them where they are")
+
+// checkDot reports whether -dot is valid.
+func checkDot() error {
+	switch *dotPlace {
+	case "keep", "top", "bottom", "error":
+		return nil
+	}
+	return fmt.Errorf("bad -dot %q: want keep, top, bottom, or error", *dotPlace)
+}
+
+// placeDot sets dot in w as -dot asks, and shows it.
+// With -dot error, dot is placed at the start of the first line
+// of the output, which begins at rune offset off in the body, past
+// any alerts, naming an error location or a failed Go test, if there
+// is one, and at the top otherwise.
+func placeDot(w window, off int) {
+	addr := "#0"
+	switch *dotPlace {
+	case "keep":
+		return
+	case "bottom":
+		addr = "$"
+	case "error":
+		body, err := w.ReadAll("body")
+		if err != nil {
+			return
+		}
+		if r := []rune(string(body)); off <= len(r) {
+			if q := firstError(string(r[off:])); q >= 0 {
+				addr = fmt.Sprintf("#%d", off+q)
+			}
+		}
+	}
+	if w.Addr("%s", addr) == nil {
+		w.Ctl("dot=addr")
+		w.Ctl("show")
+	}
+}
+
+// firstError returns the rune offset in body of the first line
+// naming an error location or a failed Go test, or -1 if there is none.
+func firstError(body string) int {
+	q := 0
+	for _, l := range strings.SplitAfter(body, "\n") {
+		if diagRE.MatchString(strings.TrimSuffix(l, "\n")) || strings.HasPrefix(l, "--- FAIL") {
+			return q
+		}
+		q += utf8.RuneCountInString(l)
+	}
+	return -1
+}
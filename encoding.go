@@ -0,0 +1,100 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+var encodingFlag = flag.String("encoding", "", "convert output from `charset` (latin1, windows-1252, or shift-jis) to UTF-8")
+
+// charset maps each byte of the -encoding charset to its rune,
+// or is nil if output is taken to be UTF-8 already.
+var charset *[256]rune
+
+// multibyte converts output from an -encoding charset with
+// characters of more than one byte, or is nil. partial holds
+// the start of a character cut off at the end of the last output.
+var (
+	multibyte transform.Transformer
+	partial   []byte
+)
+
+// cp1252 holds the characters that windows-1252 has in place of the
+// C1 controls of Latin-1. Bytes it leaves undefined map to themselves.
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// setEncoding sets charset from -encoding.
+func setEncoding() error {
+	name := strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(*encodingFlag))
+	charset = nil
+	multibyte, partial = nil, nil
+	switch name {
+	case "", "utf8":
+		return nil
+	case "latin1", "iso88591":
+		charset = new([256]rune)
+		for i := range charset {
+			charset[i] = rune(i)
+		}
+	case "windows1252", "cp1252":
+		charset = new([256]rune)
+		for i := range charset {
+			charset[i] = rune(i)
+		}
+		copy(charset[0x80:0xA0], cp1252[:])
+	case "shiftjis", "sjis":
+		multibyte = japanese.ShiftJIS.NewDecoder()
+	default:
+		return fmt.Errorf("unsupported -encoding %q: want latin1, windows-1252, or shift-jis", *encodingFlag)
+	}
+	return nil
+}
+
+// decode returns p converted from the -encoding charset to UTF-8.
+// p may end partway through a character, which is then completed
+// by the next call.
+func decode(p []byte) []byte {
+	if multibyte != nil {
+		return decodeMultibyte(p)
+	}
+	if charset == nil {
+		return p
+	}
+	b := make([]byte, 0, len(p)+len(p)/4)
+	for _, c := range p {
+		b = utf8.AppendRune(b, charset[c])
+	}
+	return b
+}
+
+// decodeMultibyte is decode for a multibyte charset.
+func decodeMultibyte(p []byte) []byte {
+	src := append(partial, p...)
+	partial = nil
+	b := make([]byte, 0, 3*len(src))
+	buf := make([]byte, 4096)
+	for {
+		n, m, err := multibyte.Transform(buf, src, false)
+		b = append(b, buf[:n]...)
+		src = src[m:]
+		switch err {
+		case transform.ErrShortDst:
+			continue
+		case transform.ErrShortSrc:
+			partial = append([]byte(nil), src...)
+		}
+		return b
+	}
+}
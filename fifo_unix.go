@@ -0,0 +1,13 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin freebsd netbsd openbsd solaris
+
+package main
+
+import "syscall"
+
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
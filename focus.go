@@ -0,0 +1,103 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hherman1/F/watch"
+)
+
+var focus = flag.Bool("focus", false, "when a _test.go file triggers a run, limit go test to its package and tests")
+
+// dotsRE matches a ./... package pattern.
+var dotsRE = regexp.MustCompile(`(^|\s)\./\.\.\.(\s|$)`)
+
+// prepareFocus limits each go test in run r to the package and tests
+// of the file that triggered it, if -focus is set and that file is
+// a Go test file.
+func prepareFocus(r *watch.Run) {
+	if !*focus || !strings.HasSuffix(r.Trigger.File, "_test.go") {
+		return
+	}
+	pwd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	r.Line = focusLine(r.Line, r.Trigger.File, pwd)
+}
+
+// focusLine returns line with each go test in it limited to the tests
+// in file, using -run, and a ./... package pattern replaced by file's
+// package directory, relative to pwd. If file declares no tests or
+// cannot be parsed, line is returned unchanged.
+func focusLine(line, file, pwd string) string {
+	names := testNames(file)
+	if len(names) == 0 {
+		vlogf("focus: no tests in %s", file)
+		return line
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(pwd, file)
+	}
+	pkg, err := filepath.Rel(pwd, filepath.Dir(file))
+	if err != nil || strings.HasPrefix(pkg, "..") {
+		return line
+	}
+	pkg = "./" + filepath.ToSlash(pkg)
+	if pkg == "./." {
+		pkg = "."
+	}
+	line = dotsRE.ReplaceAllString(line, "${1}"+pkg+"${2}")
+	run := rcQuote("^(" + strings.Join(names, "|") + ")$")
+	return goTestRE.ReplaceAllLiteralString(line, "go test -run "+run)
+}
+
+// testNames returns the names of the tests, examples, and fuzz tests
+// declared in the Go file named file.
+func testNames(file string) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.SkipObjectResolution)
+	if err != nil {
+		vlogf("focus: %v", err)
+		return nil
+	}
+	var names []string
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil {
+			continue
+		}
+		for _, prefix := range []string{"Test", "Example", "Fuzz"} {
+			if isTestName(fn.Name.Name, prefix) {
+				names = append(names, fn.Name.Name)
+			}
+		}
+	}
+	return names
+}
+
+// isTestName reports whether name is prefix followed by
+// nothing or by a character other than a lower-case letter,
+// as go test requires of the functions it runs.
+func isTestName(name, prefix string) bool {
+	rest, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return false
+	}
+	if rest == "" {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return !unicode.IsLower(r)
+}
@@ -0,0 +1,115 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var foldTests = flag.Bool("fold", false, "group go test output by package under headers that open and close when executed")
+
+// pkgResultRE matches the line go test prints when a package is done.
+var pkgResultRE = regexp.MustCompile(`^(ok|FAIL|\?) *\t\S`)
+
+// Glyphs marking a package header as closed or open.
+const (
+	closedGlyph = "▸ "
+	openGlyph   = "▾ "
+)
+
+// A folder groups go test output by package. The lines a package
+// prints are held until its result line arrives and are then shown
+// under that line, which serves as a header: open, showing the lines,
+// if the package failed, and closed otherwise.
+//
+// A folder is only used from the engine's frontend goroutine.
+type folder struct {
+	blocks  []*block
+	pend    []string // lines not yet placed under a header
+	partial []byte
+}
+
+// A block is part of the folded output: a section with a header,
+// or, if header is empty, lines not followed by any package result.
+type block struct {
+	header string
+	lines  []string
+	open   bool
+}
+
+func (b *block) text() string {
+	if b.header == "" {
+		return strings.Join(b.lines, "")
+	}
+	glyph := closedGlyph
+	if b.open {
+		glyph = openGlyph
+	}
+	if !b.open {
+		return glyph + b.header
+	}
+	return glyph + b.header + strings.Join(b.lines, "")
+}
+
+// write adds p to the output, showing complete sections in out.
+func (f *folder) write(p []byte, out *view) {
+	f.partial = append(f.partial, p...)
+	for {
+		i := strings.IndexByte(string(f.partial), '\n')
+		if i < 0 {
+			break
+		}
+		f.line(string(f.partial[:i+1]), out)
+		f.partial = f.partial[i+1:]
+	}
+}
+
+func (f *folder) line(s string, out *view) {
+	if !pkgResultRE.MatchString(s) {
+		f.pend = append(f.pend, s)
+		return
+	}
+	b := &block{header: s, lines: f.pend, open: !strings.HasPrefix(s, "ok")}
+	f.pend = nil
+	f.blocks = append(f.blocks, b)
+	out.printf("%s", b.text())
+}
+
+// end shows any output not followed by a package result.
+func (f *folder) end(out *view) {
+	if len(f.partial) > 0 {
+		f.pend = append(f.pend, string(f.partial))
+		f.partial = nil
+	}
+	if len(f.pend) > 0 {
+		b := &block{lines: f.pend}
+		f.pend = nil
+		f.blocks = append(f.blocks, b)
+		out.printf("%s", b.text())
+	}
+}
+
+// toggle opens or closes the section whose header is at rune offset q
+// in the body of w, reporting whether there is one. The folded output
+// must be at rune offset off in the body and unchanged since it was shown.
+func (f *folder) toggle(w window, q, off int) bool {
+	for _, b := range f.blocks {
+		text := b.text()
+		n := utf8.RuneCountInString(text)
+		if b.header != "" && q >= off && q < off+utf8.RuneCountInString(closedGlyph+b.header) {
+			b.open = !b.open
+			if w.Addr("#%d,#%d", off, off+n) == nil {
+				w.Write("data", []byte(b.text()))
+			}
+			w.Ctl("clean")
+			return true
+		}
+		off += n
+	}
+	return false
+}
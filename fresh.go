@@ -0,0 +1,71 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+var outputs = flag.String("outputs", "", "skip runs while the files matching the space-separated `patterns` are newer than every watched file")
+
+// errUpToDate is returned by checkFresh to skip a run.
+var errUpToDate = errors.New("up to date")
+
+// checkFresh returns errUpToDate if every -outputs file exists and
+// is newer than all the files F watches, which are the run's inputs.
+// Runs started by editing the command, executing Run, or F ctl run
+// are never skipped.
+func checkFresh(r *watch.Run) error {
+	switch r.Trigger.Kind {
+	case "tag", "run", "ctl":
+		return nil
+	}
+	if *outputs == "" {
+		return nil
+	}
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	outs := make(map[string]bool)
+	var oldest time.Time
+	for _, pat := range strings.Fields(*outputs) {
+		if !filepath.IsAbs(pat) {
+			pat = filepath.Join(pwd, pat)
+		}
+		names, _ := filepath.Glob(pat)
+		if len(names) == 0 {
+			return nil // missing output
+		}
+		for _, name := range names {
+			info, err := os.Stat(name)
+			if err != nil {
+				return nil
+			}
+			outs[name] = true
+			if oldest.IsZero() || info.ModTime().Before(oldest) {
+				oldest = info.ModTime()
+			}
+		}
+	}
+	stale := false
+	err = watch.Walk(pwd, *recursive, func(name string, info fs.FileInfo) {
+		if !outs[name] && !ignoredFile(name) && !info.ModTime().Before(oldest) {
+			stale = true
+		}
+	})
+	if err != nil || stale {
+		return nil
+	}
+	return errUpToDate
+}
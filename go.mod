@@ -3,3 +3,5 @@ module github.com/hherman1/F
 go 1.21.3
 
 require 9fans.net/go v0.0.4
+
+require golang.org/x/text v0.14.0
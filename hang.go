@@ -0,0 +1,55 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+var hangAfter = flag.Duration("hang", 0, "send SIGQUIT to a run still going after `d`, so that Go programs dump their goroutines")
+var hangKill = flag.Bool("hangkill", false, "with -hang, kill a run that has not exited a second after the SIGQUIT")
+
+// hangGrace is how long -hangkill waits after the SIGQUIT.
+const hangGrace = time.Second
+
+// watchHang arranges for run r to be sent a SIGQUIT after -hang,
+// if it is still running. It returns a function that cancels that.
+func (rn *Runner) watchHang(r *watch.Run) (stop func()) {
+	if *hangAfter <= 0 {
+		return func() {}
+	}
+	var kill *time.Timer
+	t := time.AfterFunc(*hangAfter, func() {
+		rn.eng.Post(r, func() {
+			if rn.states[r.ID] == nil {
+				return
+			}
+			note := fmt.Sprintf("(still running after %v; sending SIGQUIT for a goroutine dump)\n", *hangAfter)
+			if err := rn.eng.Quit(); err != nil {
+				note = fmt.Sprintf("(still running after %v; %v)\n", *hangAfter, err)
+			}
+			rn.Output(r, []byte(note))
+			if *hangKill {
+				kill = time.AfterFunc(hangGrace, func() {
+					rn.eng.Post(r, func() {
+						if rn.states[r.ID] != nil {
+							rn.eng.Kill()
+						}
+					})
+				})
+			}
+		})
+	})
+	return func() {
+		t.Stop()
+		if kill != nil {
+			kill.Stop()
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var noDefaultIgnores = flag.Bool("no-default-ignores", false, "do not ignore changes to editor temporary and backup files")
+
+// tempSuffixRE matches the four-digit names and suffixes of the
+// temporary files some editors write and rename into place,
+// such as vim's 4913 or x.go.1234.
+var tempSuffixRE = regexp.MustCompile(`(^|[.~])[0-9]{4}$`)
+
+// ignoredFile reports whether changes to the file name should be
+// ignored, as editor noise rather than real edits: emacs lock files
+// (.#x) and backups (x~), vim swap files (.x.swp), .DS_Store, and
+// atomic-rename temporaries.
+func ignoredFile(name string) bool {
+	filterMu.RLock()
+	all := *noDefaultIgnores
+	filterMu.RUnlock()
+	if all {
+		return false
+	}
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, ".#") ||
+		strings.HasSuffix(base, "~") ||
+		strings.HasSuffix(base, ".swp") ||
+		base == ".DS_Store" ||
+		tempSuffixRE.MatchString(base)
+}
@@ -0,0 +1,231 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// An instance describes a running F, for F list. Each F with a window,
+// or running as an agent, keeps its description in a file in the
+// state directory, updating it as runs begin and end, and removes the
+// file when it exits.
+type instance struct {
+	PID     int       `json:"pid"`
+	Dir     string    `json:"dir"`
+	Win     int       `json:"win,omitempty"` // acme window ID
+	Agent   bool      `json:"agent,omitempty"`
+	Socket  string    `json:"socket,omitempty"`  // of an agent
+	Control string    `json:"control,omitempty"` // socket for F ctl
+	Started time.Time `json:"started"`
+	Command string    `json:"command"`
+	Running bool      `json:"running"`
+	Since   time.Time `json:"since"`            // when the run began or the last one ended
+	Status  string    `json:"status,omitempty"` // of the last completed run
+
+	file    string
+	control net.Listener
+}
+
+// instancesDir returns the directory holding the instance files,
+// or "" if there is none.
+func instancesDir() string {
+	dir := stateDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "instances")
+}
+
+// register records rn as a running F in the state directory:
+// an agent listening on sock, if sock is not empty.
+// It must be called before the engine runs.
+func (rn *Runner) register(sock string) {
+	dir := instancesDir()
+	if dir == "" {
+		return
+	}
+	pwd, _ := os.Getwd()
+	now := time.Now()
+	rn.inst = &instance{
+		PID:     os.Getpid(),
+		Dir:     pwd,
+		Win:     rn.winid,
+		Agent:   sock != "",
+		Socket:  sock,
+		Started: now,
+		Command: rn.cmd,
+		Since:   now,
+		file:    filepath.Join(dir, fmt.Sprintf("%d.json", os.Getpid())),
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		vlogf("register: %v", err)
+		rn.inst = nil
+		return
+	}
+	rn.saveInstance()
+}
+
+// saveInstance writes rn's instance file, if it has one.
+func (rn *Runner) saveInstance() {
+	inst := rn.inst
+	if inst == nil {
+		return
+	}
+	js, err := json.Marshal(inst)
+	if err != nil {
+		return
+	}
+	tmp := inst.file + ".tmp"
+	if err := os.WriteFile(tmp, js, 0666); err == nil {
+		err = os.Rename(tmp, inst.file)
+	}
+	if err != nil {
+		vlogf("register: %v", err)
+	}
+}
+
+// instanceBegan updates rn's instance file for a run of line beginning.
+func (rn *Runner) instanceBegan(line string) {
+	if rn.inst == nil {
+		return
+	}
+	rn.inst.Command = line
+	rn.inst.Running = true
+	rn.inst.Since = time.Now()
+	rn.saveInstance()
+}
+
+// instanceEnded updates rn's instance file for a run ending,
+// recording its status if rec is not nil.
+func (rn *Runner) instanceEnded(rec *record) {
+	if rn.inst == nil {
+		return
+	}
+	rn.inst.Running = len(rn.states) > 0
+	if !rn.inst.Running {
+		rn.inst.Since = time.Now()
+	}
+	if rec != nil {
+		rn.inst.Status = rec.status()
+	}
+	rn.saveInstance()
+}
+
+// unregister removes rn's instance file and closes its control socket.
+func (rn *Runner) unregister() {
+	if rn.inst == nil {
+		return
+	}
+	if rn.inst.control != nil {
+		rn.inst.control.Close()
+		os.Remove(rn.inst.Control)
+	}
+	os.Remove(rn.inst.file)
+	rn.inst = nil
+}
+
+// status describes how the run recorded by rec ended.
+func (rec *record) status() string {
+	switch {
+	case rec.Error == "":
+		return "ok"
+	case rec.ExitCode > 0:
+		return "exit " + strconv.Itoa(rec.ExitCode)
+	}
+	return rec.Error
+}
+
+// listInstances returns the running instances of F, by directory,
+// removing the files left by any that exited without doing so.
+func listInstances() ([]instance, error) {
+	dir := instancesDir()
+	if dir == "" {
+		return nil, fmt.Errorf("no state directory: F does not record its instances with -state=none")
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var list []instance
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		var inst instance
+		if json.Unmarshal(b, &inst) != nil {
+			continue
+		}
+		if !processAlive(inst.PID) {
+			os.Remove(name)
+			continue
+		}
+		list = append(list, inst)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Dir != list[j].Dir {
+			return list[i].Dir < list[j].Dir
+		}
+		return list[i].PID < list[j].PID
+	})
+	return list, nil
+}
+
+// runList prints the running instances of F to out, for F list,
+// and returns the exit status for F.
+func runList(out io.Writer) int {
+	list, err := listInstances()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "F: %v\n", err)
+		return 1
+	}
+	if len(list) == 0 {
+		fmt.Fprintf(out, "no F running\n")
+		return 0
+	}
+	now := time.Now()
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "PID\tWINDOW\tDIRECTORY\tSTATE\tCOMMAND\n")
+	for _, inst := range list {
+		win := strconv.Itoa(inst.Win)
+		switch {
+		case inst.Agent:
+			win = "agent"
+		case inst.Win == 0:
+			win = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", inst.PID, win, inst.Dir, inst.state(now), oneLine(inst.Command))
+	}
+	tw.Flush()
+	return 0
+}
+
+// state describes what inst is doing as of now.
+func (inst *instance) state(now time.Time) string {
+	d := now.Sub(inst.Since).Round(time.Second)
+	switch {
+	case inst.Running:
+		return fmt.Sprintf("running for %v", d)
+	case inst.Status == "":
+		return "idle"
+	}
+	return fmt.Sprintf("%s %v ago", inst.Status, d)
+}
+
+// oneLine returns the multi-line command cmd on one line.
+func oneLine(cmd string) string {
+	return strings.ReplaceAll(cmd, "\n", "; ")
+}
@@ -14,7 +14,8 @@
 // Watch reexecutes the command and updates the window.
 //
 // The command and arguments are joined by spaces and passed to rc(1)
-// to be interpreted as a shell command line.
+// (on Plan 9, the system rc), or to the shell named by -shell, to be
+// interpreted as a shell command line.
 //
 // The command is printed at the top of the window, preceded by a "% " prompt.
 // Changing that line changes the command run each time the window is updated.
@@ -23,10 +24,362 @@
 //
 // Executing Quit sends a SIGQUIT on systems that support that signal.
 // (Go programs receiving that signal will dump goroutine stacks and exit.)
+// Windows and Plan 9 have no such signal, and there Quit says so.
+// Executing Kill stops the run, and the processes it started, by asking
+// and then forcing them: on Unix, with SIGINT, SIGTERM, and SIGKILL sent
+// to its process group; on Windows, with a CTRL_BREAK sent to its
+// process group and the termination of its job object; and on Plan 9,
+// with the interrupt and kill notes posted to its note group.
 //
-// Executing Kill stops any commands being executed. On Unix it sends the commands
-// a SIGINT, followed 100ms later by a SIGTERM, followed 100ms later by a SIGKILL.
-// On other systems it sends os.Interrupt followed 100ms later by os.Kill
+// F runs the command as soon as it starts, unless the -norun flag is
+// given, in which case it waits for the first Put. Executing Run runs
+// the command at any time. Executing Run with an argument, as by
+// chording 2-1 on Run with a test name selected, passes the argument to
+// that run as $ARG and in place of {} in the command, quoted for rc, so
+// that "go test -run {}" reruns just the selected test. Runs without an
+// argument leave {} alone, for commands such as find -exec.
+//
+// Each run's command is told about the run in its environment:
+// $F_RUN_ID numbers the runs from 1, $F_TRIGGER gives what caused the
+// run (start, put, file, run for an executed Run, tag for a tag edit,
+// and so on), and $F_PREV_STATUS holds the exit status of the previous
+// run, even one from an earlier session, -1 if it could not be started
+// or was killed by a signal; it is unset before the first run.
+//
+// Commands are run with $winid set to the ID of F's window, and, when
+// the run was triggered by a Put, with $F_WINID and $% set to the ID
+// and file name of the window that was Put, so that a command can
+// drive acme itself, for example to show the line of a failure in the
+// window being edited.
+//
+// Each run's output replaces the previous run's by editing only the lines
+// that differ, so that when a run prints exactly what the previous one did,
+// none of its lines are rewritten and the window keeps its scroll position
+// and selection. If the run takes more than a quarter second, the only
+// writes are those placing the stale-output banner (see below) above the
+// output and removing it once the run ends; otherwise the window body is
+// not written at all.
+//
+// The -match flag gives a regular expression, such as 'WARN|TODO'.
+// After each run, the lines of output that match it are listed in a
+// section above the output, as well as being left in place.
+//
+// The -dot flag says where to put dot after each run, scrolling the
+// window to show it: at the top of the output, at the bottom, as when
+// following a log, at the first line naming an error location or a
+// failed Go test, or, by default, wherever it was.
+//
+// Puts of tool windows, those whose names end in a /+ element such as
+// /+Errors, /+f, or /+watch, are ignored, so that the output of one tool
+// cannot trigger another in a loop. The -toolwins flag includes them.
+//
+// The -events flag names a file to which F appends one JSON object per run,
+// recording the start time, trigger, command, exit code, duration, and the
+// tail of the output.
+//
+// The -errors flag sends output to the directory's +Errors window,
+// creating it if necessary, instead of a dedicated +f window.
+// Each run's output is appended below a "% cmd" line rather than
+// replacing the previous output, so that diagnostics from F and other
+// acme tools collect in one place.
+//
+// The -acme flag names the acme to connect to, either as a service
+// posted in the name space directory or as a dial string such as
+// tcp!host!564, so that F can be used with acme clones like edwood
+// or with an acme forwarded from another machine.
+//
+// The -ns flag overrides $NAMESPACE, selecting which plan9port
+// name space F (and the commands it runs) attach to.
+//
+// The -fs flag makes F also rerun the command when files in the
+// directory change on disk, however they were written. A file changed
+// while the command is running triggers another run once it ends,
+// unless the run before changed the file too, taking it to be the
+// command's own output (go generate, gofmt -w); so a command that
+// writes files settles after one rerun. Changes to the temporary
+// and backup files of common editors (.#x, x~, x.swp, .DS_Store, and
+// names ending in four digits, like vim's 4913) are ignored, unless
+// the -no-default-ignores flag is given. The -r flag extends both Put
+// and file watching to all subdirectories.
+//
+// The -delay flag makes F wait after a trigger before running the
+// command, restarting the wait on each further trigger, so that tools
+// that write several files in turn (goimports, gopls) can finish first.
+//
+// When more than 50 triggers arrive within a second (see -storm), as when
+// a git checkout rewrites many files, F stops starting runs and instead
+// runs the command once, after half a second without triggers. Files
+// changed while the command is running count too (except the
+// command's own output), so a storm during a run stops it and runs
+// the command once afterward.
+//
+// The -speculate flag starts a run as soon as a window of a file in the
+// directory is modified, without waiting for it to be Put, so that the
+// slow parts of a build (compiling dependencies, say) are under way by
+// the time it is. The Put then starts the real run as usual.
+//
+// Conversely, the -cancel-on-edit flag kills the current run as soon as
+// a window of a file in the directory is modified, since its result is
+// already stale, freeing the machine for the run that the Put will start.
+//
+// On battery power (detected on Linux), or always with -lowpower, F
+// saves energy: it waits at least two seconds after a trigger before
+// running (as with -delay), runs commands at low priority, and skips
+// -speculate and -warm runs.
+//
+// File systems on which the system cannot report changes, such as a
+// remote machine's files mounted with 9pfuse, v9fs, or NFS, are watched
+// by rescanning the tree every second. So are directories beyond the
+// system's limit on inotify watches, with a warning naming them.
+// The -poll flag forces polling, at the given interval, for any
+// file system. Polling compares each scan with the one before, not
+// with this machine's clock, so a file server whose clock disagrees
+// neither hides edits nor reruns the command for its own output.
+//
+// When watching subdirectories, F does not follow symbolic links
+// unless the -L flag is given, and then skips any link back to a
+// directory above it, so that loops end. The -xdev flag keeps F from
+// watching directories on file systems other than the current one.
+//
+// In a very large tree, the -lazy flag makes F start up quickly by
+// watching only the given number of levels of directories at first.
+// Deeper directories are added in the background, those below recently
+// changed directories first. Until a directory is added, changes in it
+// are missed.
+//
+// The -staged flag runs the command whenever the git index changes,
+// instead of on each Put, giving a live pre-commit check. The names
+// of the staged files replace {staged} in the command, quoted for rc,
+// and are also passed one per line in $F_STAGED.
+//
+// The -outputs flag lists the files, or glob patterns, that the command
+// builds. Before each run, F compares their modification times with
+// those of the files it watches, and if every output is newer than all
+// the inputs, it prints "up to date" instead of running the command.
+// Editing the command, executing Run, or F ctl run always runs it.
+//
+// The -fold flag groups go test output by package. Each package's
+// output is shown under its result line ("ok", "FAIL", or "?"), which
+// becomes a header marked ▾ when open and ▸ when closed. Headers of
+// failed packages start open, others closed. Executing (clicking with
+// button 2 on) a header opens or closes it.
+//
+// The -warm flag gives a command, such as "go build ./... && go test
+// -run XXX ./...", that F runs at low priority after each run, to warm
+// the build cache for the next one. It is killed when the next run
+// begins. Its output is shown, after the run's, only if it fails.
+//
+// The -quickfix flag names a file that F rewrites after each run with
+// the file:line:col locations found in the output, one per line, in
+// the format read by vim's quickfix list and similar tools, so that
+// other editors on the same checkout can follow the watch loop.
+//
+// The -fifo flag names a pipe to which F copies each run's command line
+// and output, so that the same run can be followed from a terminal,
+// for example with cat in a tmux pane.
+//
+// While a new run has yet to produce output, the previous run's output
+// stays in the window, below a banner noting that it is stale, and is
+// replaced line by line as the new output arrives.
+//
+// Output is written to the window at most 20 times a second (see -wps),
+// so that a command printing at full speed does not freeze acme.
+// Output arriving faster than that is held back and written in larger
+// pieces, followed by a line noting that output is being throttled.
+//
+// Output beyond the first 256 kB of a run (see -spill) is kept in a
+// temporary file instead of the window, which shows only the start and
+// end of it, separated by a line noting what was omitted. Executing More
+// brings the next part of the omitted output into the window.
+//
+// The -encoding flag names the character set of the command's output,
+// latin1, windows-1252, or shift-jis, which F converts to UTF-8 before
+// showing it.
+//
+// The -mem flag bounds the memory F uses to hold output, 64 MB by
+// default. If need be it lowers the -spill limit, even when -spill is 0,
+// and a line too long for the budget is written to the window in pieces
+// rather than held until it is complete.
+//
+// Executing Debug after a run in which Go tests failed opens a win
+// window running the first failed test under dlv test. Executing
+// Debug with a test name as argument debugs that test instead.
+//
+// The command runs to the end of its line in the tag, unless the line
+// ends in a backslash, in which case the command continues on the next
+// line. Continued lines are passed to the shell intact. Later lines of
+// the tag beginning with % hold further commands, run after the first.
+// A command beginning with #, as in "%# go test -race ./...", is
+// skipped, so that it can be turned off and on by editing one character.
+//
+// The commands of a tag with several run in the order they are written,
+// one after another, whatever their exit statuses. With -order=fast,
+// they run quickest first, by how long each took when last run, so that
+// cheap checks like go vet report before slow ones like go test -race;
+// commands not yet timed run first. With -failfast, the commands after
+// the first that fails are not run. Either way, F times each command,
+// keeping the times in the history, and notes at the top of the window
+// the order the commands ran in, if it was not the tag's, and any that
+// were skipped after a failure.
+//
+// Editing the command after the % in the tag reruns it. Until the
+// new command has started, the window is marked as modified.
+// Edits to the rest of the tag do not cause a run.
+//
+// The -tmp flag gives each run a new, empty temporary directory,
+// passed to the command as $TMPDIR and $F_TMP, and removes it with
+// everything in it when the run ends, so that files leaked by tests
+// do not pile up over a long session.
+//
+// The -hang flag gives a duration after which a run still going is
+// sent a SIGQUIT, so that a hung Go program prints the stacks of its
+// goroutines to the window. With -hangkill, a run that has not exited
+// a second later is killed.
+//
+// The -timeout flag kills any run that lasts longer than the given
+// duration.
+//
+// The -cmdfile flag names a file holding the command, which may span
+// several lines, to use instead of the command line and the tag.
+// F rereads the file for each run and runs the command whenever the
+// file changes. Run with no command in a directory holding a .f file,
+// F takes its command from that file, as with -cmdfile .f.
+//
+// F reads flags, one per line, from the file given by -config, or from
+// $XDG_CONFIG_HOME/F/flags (on macOS, ~/Library/Application Support/F/flags)
+// if it exists, before those on the command line, which take precedence.
+// A line holds -name=value, -name value, or, for a boolean flag, just
+// -name; lines beginning with # are comments. When the file changes,
+// F rereads it and applies the flags that affect how the window shows
+// each run, such as -match, -dot, -encoding, -fold, and -hang, the
+// filters -toolwins and -no-default-ignores, and -shell, for the runs
+// that follow, announcing the change in the window; flags that cannot change while F runs, such as
+// -fs, are reported as needing a restart, and a file with a mistake in
+// it is reported and not applied.
+//
+// The -projects flag treats the arguments as directories and starts
+// a separate F in each, running the command in that directory's .f file
+// (as with -cmdfile), with a window of its own. A +projects window
+// summarizes them, showing for each whether its latest run passed or
+// failed, so that a set of projects can be followed as a small local CI.
+// Deleting the +projects window, or interrupting F, stops them.
+//
+// Interrupting F, as by typing ^C in the terminal that started it,
+// kills the current run and deletes F's window.
+//
+// The -once flag runs the command a single time, without acme, copying
+// its output to standard output, and exits with the command's exit
+// status, so that F can gate git hooks and scripts. If the run timed
+// out, F exits with status 124; if F was interrupted, 130; if the command
+// was killed by some other signal, 125; and if it could not be started,
+// 127. The -staged, -outputs, -events, and -fifo flags apply as usual.
+//
+// Executing Stats prints the number of runs in this session, how many
+// failed, and their mean, 95th percentile, and longest durations, and the
+// same for all runs recorded in the -events file, or, without -events,
+// for the recent runs kept in the state directory.
+//
+// Executing Only with a test name or pattern as argument, or with one
+// selected in the window, adds -run and the pattern to each go test in
+// the command for the following runs, which are marked by a note above
+// the output. Executing Only off goes back to running all the tests.
+//
+// The -focus flag makes a run triggered by a change to a Go test file
+// run only that file's tests: each go test in the command gets -run with
+// the names of the tests, examples, and fuzz tests the file declares,
+// and a ./... package pattern is replaced by the file's package.
+// Changes to other files run the command as it is.
+//
+// Executing Compare with a second command as argument makes each run
+// execute the command in the tag and then the second command, showing
+// a diff of their outputs instead of the output itself, so that a change
+// can be checked continuously for differences in behavior. Executing
+// Compare without an argument goes back to running the command alone.
+//
+// A run that takes more than three times the median duration of the
+// latest runs of the same command, as recorded in the history below,
+// is flagged by a banner above its output. The -slow flag sets the
+// factor; -slow 0 turns the banners off.
+//
+// F keeps a history of the last 100 runs in each directory, with their
+// results, durations, and the ends of their output, in a state directory
+// ($XDG_STATE_HOME/F, or ~/.local/state/F, unless set by -state), so that
+// features such as Stats can draw on runs from earlier sessions.
+//
+// The -porcelain flag makes F print to standard output a JSON object
+// on a line of its own for each trigger, each run's start and exit,
+// and each run killed or timed out, so that scripts can follow the runs
+// without reading the window.
+//
+// The -v flag logs to standard error each trigger and why it did or
+// did not cause a run, the shell command run, the signals sent to runs,
+// and any errors writing to the window.
+//
+// F asks acme to record, in any dump file, that the window is restored
+// by running F again in the same directory. Against an acme clone that
+// does not support this, F says so and carries on. The -nodump flag
+// stops F from asking.
+//
+// The -agent flag runs F without acme, watching the file system as with
+// -fs and keeping the window in memory. F -attach, run in the same
+// directory, opens an acme window showing the agent's window, through
+// which it can be used as usual; deleting the window leaves the agent
+// running, and attaching again shows its latest state. The agent
+// listens on a Unix socket in the state directory, or on the one named
+// by -socket, which an F -attach on another machine can reach through
+// a forwarded socket, as with ssh -L. Several windows may be attached
+// at once; an edit to the tag of one is shown in the others. The agent
+// stops when interrupted, stopping any run as Kill does.
+//
+// F attach dir shows the window of the F already running in dir, rather
+// than starting another to compete with it: the window of an F running
+// in acme, or that of an agent, in the window attached to it or, if
+// there is none, in a new one, as F -attach would. It finds the F in dir
+// as F list does.
+//
+// F ctl request sends a request to the F running in the current
+// directory, so that acme scripts and other programs can drive it,
+// and prints the reply. The requests are run, with an optional
+// argument, as for Run; kill, as for Kill; set cmd command, which
+// replaces the first command in the tag with command and runs it;
+// and status, which prints lines of the form "name value" describing
+// the F and its last run. F ctl exits with status 1 if the request
+// fails. For example,
+//
+//	F ctl set cmd 'go test -run ^TestParse$'
+//
+// Each F listens for requests on a socket in the state directory.
+//
+// Problems F meets while it runs, such as a watcher that cannot be
+// started or an internal error, are reported at the end of the window
+// body and in F's standard error, and F carries on as best it can.
+//
+// F doctor checks that F can work here: that rc can be found, that
+// there is a plan9port name space with an acme in it and acme can be
+// reached, that the state directory is writable, and that the system
+// allows enough inotify watches for the directories below this one.
+// It prints what it finds, with a suggested fix for any problem, and
+// exits with status 1 if there is a problem. (To run a command called
+// doctor, give its path, as in F ./doctor.)
+//
+// F list lists the instances of F running, with or without a window,
+// for any directory: for each, its process ID, acme window ID, and
+// directory, the command in its tag, and whether that command is
+// running and for how long, or how its last run ended. Each F records
+// itself in the state directory for F list (see -state), so F list
+// finds no instances of F run with -state=none.
+//
+// If acme exits, F waits for it to be restarted and then reopens its
+// window with the most recently run command. With -daemon, F instead
+// carries on meanwhile: it watches the file system, as with -fs, since
+// it can no longer see Puts, runs the command as files change, and
+// logs how each run ends, keeping the runs in its history as usual.
+// Once acme is back and any run in progress has finished, F reopens
+// its window.
+//
+// The -webhook flag, with or without -daemon, posts each completed run
+// to a URL, as a JSON object like those written by -events, with the
+// directory of the run added as "dir".
 package main // import "9fans.net/go/acme/Watch"
 
 import (
@@ -34,20 +387,34 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"os/signal"
+	"path"
 	"strings"
-	"sync"
+	"time"
 
 	"9fans.net/go/acme"
+	"github.com/hherman1/F/watch"
 )
 
 var args []string
 var win *acme.Win
-var needrun = make(chan bool, 1)
+var errorsMode = flag.Bool("errors", false, "write output to the directory's +Errors window")
+var fsWatch = flag.Bool("fs", false, "also rerun when files change on disk, not just when they are Put")
+var recursive = flag.Bool("r", false, "watch all subdirectories recursively")
+var followLinks = flag.Bool("L", false, "with -fs or -r, follow symbolic links to directories")
+var xdev = flag.Bool("xdev", false, "with -fs or -r, do not watch directories on other file systems")
+var lazyDepth = flag.Int("lazy", 0, "with -fs -r, watch `n` levels of directories at once and deeper ones in the background")
+var pollEvery = flag.Duration("poll", 0, "with -fs, watch files by rescanning the tree every `d` instead of asking the system")
+var noRun = flag.Bool("norun", false, "do not run the command until the first trigger")
+var delay = flag.Duration("delay", 0, "wait `d` after the last trigger before running")
+var stormRate = flag.Int("storm", 50, "treat more than `n` triggers a second as a storm, running once they stop (0 for no limit)")
+var noDump = flag.Bool("nodump", false, "do not ask acme to record how to restore the window for Load")
+var toolWindows = flag.Bool("toolwins", false, "also rerun when tool windows such as +Errors are Put")
+var shellFlag = flag.String("shell", "", "run commands with `shell` -c instead of rc")
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: F cmd args...\n")
+	fmt.Fprintf(os.Stderr, "usage: F [options] cmd args...\n")
+	flag.PrintDefaults()
 	os.Exit(2)
 }
 
@@ -57,192 +424,334 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 	args = flag.Args()
+	if err := loadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	watch.FollowSymlinks = *followLinks
+	watch.OneFileSystem = *xdev
+	watch.LazyDepth = *lazyDepth
 
-	var err error
-	win, err = acme.New()
-	if err != nil {
+	if err := openEvents(); err != nil {
 		log.Fatal(err)
 	}
+	if err := openMirror(); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if len(args) == 1 && args[0] == "doctor" {
+		os.Exit(runDoctor(os.Stdout))
+	}
+	if len(args) == 1 && args[0] == "list" {
+		os.Exit(runList(os.Stdout))
+	}
+	if len(args) >= 2 && args[0] == "ctl" {
+		os.Exit(runCtl(os.Stdout, args[1:]))
+	}
+	if len(args) == 0 && *cmdFile == "" && !*projectsMode {
+		if _, err := os.Stat(".f"); err == nil {
+			*cmdFile = ".f"
+		}
+	}
+	if *once {
+		os.Exit(runOnce())
+	}
+	if *agentMode {
+		runAgent()
+	}
+	if err := connectAcme(); err != nil {
+		log.Fatal(err)
+	}
+	if *attachMode {
+		runAttach()
+	}
+	if len(args) == 2 && args[0] == "attach" {
+		runAttachDir(args[1])
+	}
+	if *projectsMode {
+		runProjects()
+	}
+
 	pwd, _ := os.Getwd()
 	pwdSlash := strings.TrimSuffix(pwd, "/") + "/"
-	win.Name(pwdSlash + "+f")
-	win.Ctl("clean")
-	win.Ctl("dumpdir " + pwd)
-	cmd := "dump F"
-	win.Ctl(cmd)
-	win.Fprintf("tag", "Kill Quit +NoSuggest %% %s", strings.Join(args, " "))
-
-	needrun <- true
-	go events()
-	go runner()
-	r, err := acme.Log()
+	var err error
+	win, err = openWin(pwdSlash)
 	if err != nil {
 		log.Fatal(err)
 	}
-	for {
-		_, err := r.Read()
+	win.SetErrorPrefix(pwdSlash)
+	win.Ctl("clean")
+	if !*errorsMode && !*noDump {
+		if err := setDump(win, pwd); err != nil {
+			log.Printf("%v; Load will not restore the F window", err)
+		}
+	}
+	win.Fprintf("tag", "%s", initialTag())
+
+	rn := newRunner(win)
+	rn.winid = win.ID()
+	rn.register("")
+	rn.listenControl()
+	eng := rn.eng
+	watchPower(eng)
+	if !*noRun {
+		eng.Kick(watch.Trigger{Kind: "start"})
+	}
+	go events(rn)
+	go stopOnInterrupt(rn)
+	go eng.Run()
+	startWatchers(rn, pwd)
+	if *speculate || *cancelOnEdit {
+		err := watchDirty(pwd, pwdSlash, func(name string) {
+			if *cancelOnEdit {
+				vlogf("%s modified: killing the current run", name)
+				eng.Kill()
+			}
+			if *speculate && !lowPower() {
+				eng.Kick(watch.Trigger{Kind: "dirty", File: name})
+			}
+		})
 		if err != nil {
-			log.Fatal(err)
+			rn.report("watch windows: %v", err)
 		}
 	}
+	watchLog(rn, pwd)
+}
 
+// initialTag returns the text F adds to its window's tag.
+func initialTag() string {
+	cmd := strings.Join(args, " ")
+	if *cmdFile != "" {
+		cmd = "<" + *cmdFile
+	}
+	return "Run Kill Quit Debug +NoSuggest % " + cmd
 }
 
-func events() {
-	for e := range win.EventChan() {
-		switch e.C2 {
-		case 'i', 'd':
-			select {
-			case needrun <- true:
-			default:
-			}
-		case 'x', 'X': // execute
-			if string(e.Text) == "Kill" {
-				run.Lock()
-				cmd := run.cmd
-				run.kill = true
-				run.Unlock()
-				if cmd != nil {
-					kill(cmd)
-				}
-				continue
-			}
-			if string(e.Text) == "Quit" {
-				run.Lock()
-				cmd := run.cmd
-				run.Unlock()
-				if cmd != nil {
-					quit(cmd)
+// startWatchers starts the sources of triggers for rn other than acme:
+// the file system with -fs, the command file with -cmdfile, and the git
+// index with -staged. Any that cannot be started are reported in the
+// window, and F carries on without them. It also starts rereading the
+// config file as it changes.
+func startWatchers(rn *Runner, pwd string) {
+	eng := rn.eng
+	if *fsWatch {
+		watchFiles(rn, pwd)
+	}
+	if *cmdFile != "" {
+		go watchCmdFile(*cmdFile, eng.Kick)
+	}
+	go watchConfig(rn)
+	if *stagedMode {
+		if err := watchStaged(eng.Kick); err != nil {
+			rn.report("%v", err)
+		}
+	}
+}
+
+// watchFiles triggers rn's runs when files in pwd change.
+func watchFiles(rn *Runner, pwd string) {
+	eng := rn.eng
+	changed := func(name string) {
+		if ignoredFile(name) {
+			vlogf("file %s: editor temporary: ignored", name)
+			return
+		}
+		eng.FileChanged(name)
+	}
+	var err error
+	if *pollEvery > 0 {
+		err = watch.Poll(pwd, *recursive, *pollEvery, changed)
+	} else {
+		err = watch.Files(pwd, *recursive, changed)
+	}
+	if err != nil {
+		rn.report("watch files: %v", err)
+	}
+}
+
+// watchLog reads the acme log, starting a run for each Put in pwd.
+// If the log cannot be opened or read, watchLog retries with
+// exponential backoff, exiting only once F's window is gone.
+func watchLog(rn *Runner, pwd string) {
+	eng := rn.eng
+	pwdSlash := strings.TrimSuffix(pwd, "/") + "/"
+	delay := minLogDelay / 2
+	for {
+		var n int
+		r, err := acme.Log()
+		if err == nil {
+			n, err = readLog(eng, r, pwd, pwdSlash)
+			r.Close()
+		}
+		delay = logDelay(delay, n)
+		vlogf("acme log: %v; retrying in %v", err, delay)
+		if _, werr := win.ReadAll("ctl"); werr != nil {
+			if acmeGone() {
+				if *daemon {
+					unattended(rn, pwd)
 				}
-				continue
-			}
-			if string(e.Text) == "Del" {
-				win.Ctl("delete")
+				restart(eng)
 			}
+			log.Fatalf("acme log: %v", err)
 		}
-		win.WriteEvent(e)
+		time.Sleep(delay)
 	}
-	os.Exit(0)
 }
 
-var run struct {
-	sync.Mutex
-	id   int
-	cmd  *exec.Cmd
-	kill bool
+const (
+	minLogDelay = 100 * time.Millisecond
+	maxLogDelay = 30 * time.Second
+)
+
+// logDelay returns how long to wait before reopening the acme log,
+// given the last wait, d, and the number of events read since, n.
+// The wait doubles with each failure in a row, up to maxLogDelay,
+// and starts over once the log has been read.
+func logDelay(d time.Duration, n int) time.Duration {
+	if n > 0 {
+		return minLogDelay
+	}
+	if d *= 2; d > maxLogDelay {
+		d = maxLogDelay
+	}
+	return d
 }
 
-func runner() {
-	for range needrun {
-		run.Lock()
-		run.id++
-		id := run.id
-		lastcmd := run.cmd
-		run.cmd = nil
-		run.kill = false
-		run.Unlock()
-		if lastcmd != nil {
-			kill(lastcmd)
+// readLog processes events from r until an error occurs,
+// returning the number of events read and the error.
+func readLog(eng *watch.Engine, r *acme.LogReader, pwd, pwdSlash string) (int, error) {
+	for n := 0; ; n++ {
+		ev, err := r.Read()
+		if err != nil {
+			return n, err
+		}
+		if ev.Op != "put" {
+			continue
+		}
+		if why := putIgnored(ev.Name, pwd, pwdSlash); why != "" {
+			vlogf("put %s: %s", ev.Name, why)
+			continue
 		}
-		lastcmd = nil
+		eng.Kick(watch.Trigger{Kind: "put", File: ev.Name, Win: ev.ID})
+		// slow down any runaway loops
+		time.Sleep(100 * time.Millisecond)
+	}
+}
 
-		runSetup(id)
-		go runBackground(id)
+// putIgnored returns why a Put of the file name does not trigger a run,
+// or "" if it does.
+func putIgnored(name, pwd, pwdSlash string) string {
+	filterMu.RLock()
+	toolwins := *toolWindows
+	filterMu.RUnlock()
+	switch {
+	case *stagedMode:
+		return "ignored with -staged"
+	case !toolwins && isToolWindow(name):
+		return "tool window: ignored"
+	case !inDir(name, pwd, pwdSlash):
+		return "not in " + pwd + ": ignored"
 	}
+	return ""
 }
 
-func runSetup(id int) {
-	// Running synchronously in runner, so no need to watch run.id.
-	// reset window
-	win.Addr(",")
-	win.Write("data", nil)
-	win.Addr("#0")
+// inDir reports whether the file name is one F watches:
+// one in pwd, or with -r, anywhere under it.
+func inDir(name, pwd, pwdSlash string) bool {
+	return path.Dir(name) == pwd || *recursive && strings.HasPrefix(name, pwdSlash)
 }
 
-func readCmd() (string, error) {
-	bs, err := win.ReadAll("tag")
-	if err != nil {
-		return "", fmt.Errorf("read tag: %w", err)
+// isToolWindow reports whether name is that of an acme tool window,
+// such as dir/+Errors, dir/+f, or dir/+watch, rather than a file.
+func isToolWindow(name string) bool {
+	return strings.HasPrefix(path.Base(name), "+")
+}
+
+// setDump tells acme how to restore w when a dump file is Loaded:
+// by running F in dir. Acme clones that do not support the dump
+// ctl messages reject them, and setDump returns the error.
+func setDump(w window, dir string) error {
+	if err := w.Ctl("dumpdir %s", dir); err != nil {
+		return fmt.Errorf("acme does not support dumpdir: %w", err)
 	}
-	_, after, ok := strings.Cut(string(bs), "%")
-	if !ok {
-		return "", nil
+	if err := w.Ctl("dump F"); err != nil {
+		return fmt.Errorf("acme does not support dump: %w", err)
 	}
-	return strings.TrimSpace(after), nil
+	return nil
 }
 
-func runBackground(id int) {
-	buf := make([]byte, 4096)
-	run.Lock()
-	line, err := readCmd()
-	if err != nil {
-		log.Fatalf("Load command: %v", err)
-	}
-	run.Unlock()
-
-	// Find the plan9port rc.
-	// There may be a different rc in the PATH,
-	// but there probably won't be a different 9.
-	// Don't just invoke 9, because it will change
-	// the PATH.
-	var rc string
-	if dir := os.Getenv("PLAN9"); dir != "" {
-		rc = filepath.Join(dir, "bin/rc")
-	} else if nine, err := exec.LookPath("9"); err == nil {
-		rc = filepath.Join(filepath.Dir(nine), "rc")
-	} else {
-		rc = "/usr/local/plan9/bin/rc"
+// openWin returns the window F writes to: a new +f window,
+// or with -errors the directory's existing +Errors window, if any.
+func openWin(pwdSlash string) (*acme.Win, error) {
+	if !*errorsMode {
+		w, err := acme.New()
+		if err != nil {
+			return nil, err
+		}
+		w.Name(pwdSlash + "+f")
+		return w, nil
 	}
-
-	cmd := exec.Command(rc, "-c", string(line))
-	r, w, err := os.Pipe()
+	name := pwdSlash + "+Errors"
+	ws, err := acme.Windows()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	cmd.Stdout = w
-	cmd.Stderr = w
-	isolate(cmd)
-	err = cmd.Start()
-	w.Close()
-	run.Lock()
-	if run.id != id || run.kill {
-		r.Close()
-		run.Unlock()
-		kill(cmd)
-		return
+	for _, info := range ws {
+		if info.Name == name {
+			return acme.Open(info.ID, nil)
+		}
 	}
+	w, err := acme.New()
 	if err != nil {
-		r.Close()
-		win.Fprintf("data", "(exec: %s)\n", err)
-		run.Unlock()
-		return
-	}
-	run.cmd = cmd
-	run.Unlock()
-	bol := true
-	for {
-		n, err := r.Read(buf)
-		if err != nil {
-			break
-		}
-		run.Lock()
-		if id == run.id && n > 0 {
-			p := buf[:n]
-			win.Write("data", p)
-			bol = p[len(p)-1] == '\n'
-		}
-		run.Unlock()
-	}
-	err = cmd.Wait()
-	run.Lock()
-	if id == run.id {
-		// If output was missing final newline, print trailing backslash and add newline.
-		if !bol {
-			win.Fprintf("data", "\\\n")
-		}
-		if err != nil {
-			win.Fprintf("data", "(%v)\n", err)
+		return nil, err
+	}
+	w.Name(name)
+	return w, nil
+}
+
+// stopOnInterrupt waits for F to be interrupted, as -projects does to
+// the F of each project it stops, and then kills the current run,
+// removes F's files, and deletes its window before exiting.
+func stopOnInterrupt(rn *Runner) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+	rn.close()
+	rn.eng.Stop()
+	if !*errorsMode {
+		win.Ctl("delete")
+	}
+	os.Exit(130)
+}
+
+func events(rn *Runner) {
+	for e := range win.EventChan() {
+		switch e.C2 {
+		case 'i', 'd':
+			if err := rn.tagEdited(); err != nil {
+				log.Print(err)
+			}
+		case 'x', 'X': // execute
+			if e.C2 == 'X' && rn.toggle(e.Q0) {
+				continue
+			}
+			if rn.execute(string(e.Text), strings.TrimSpace(string(e.Arg))) {
+				continue
+			}
+			if string(e.Text) == "Del" {
+				win.Ctl("delete")
+			}
 		}
+		win.WriteEvent(e)
+	}
+	if *daemon && acmeGone() {
+		pwd, _ := os.Getwd()
+		unattended(rn, pwd)
 	}
-	run.Unlock()
+	rn.close()
+	if acmeGone() {
+		restart(rn.eng)
+	}
+	os.Exit(0)
 }
@@ -0,0 +1,76 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var matchFlag = flag.String("match", "", "list the output lines matching `regexp` in a section above the output")
+
+// maxMatches bounds the lines listed above the output.
+const maxMatches = 100
+
+var matchRE *regexp.Regexp
+
+// compileMatch compiles -match, if set.
+func compileMatch() error {
+	matchRE = nil
+	if *matchFlag == "" {
+		return nil
+	}
+	re, err := regexp.Compile(*matchFlag)
+	if err != nil {
+		return fmt.Errorf("bad -match: %v", err)
+	}
+	matchRE = re
+	return nil
+}
+
+// A matchScanner collects the output lines matching -match.
+type matchScanner struct {
+	lines []string
+	n     int // number of matching lines, including any not kept
+}
+
+func (s *matchScanner) line(line string) {
+	if matchRE == nil || !matchRE.MatchString(line) {
+		return
+	}
+	s.n++
+	if len(s.lines) < maxMatches {
+		s.lines = append(s.lines, line)
+	}
+}
+
+// section returns the section listing the matching lines,
+// or "" if -match is not set.
+func (s *matchScanner) section() string {
+	if matchRE == nil {
+		return ""
+	}
+	var b strings.Builder
+	if s.n == 1 {
+		fmt.Fprintf(&b, "1 line matches %s", matchRE)
+	} else {
+		fmt.Fprintf(&b, "%d lines match %s", s.n, matchRE)
+	}
+	if s.n == 0 {
+		b.WriteString("\n\n")
+		return b.String()
+	}
+	b.WriteString(":\n")
+	for _, l := range s.lines {
+		fmt.Fprintf(&b, "\t%s\n", l)
+	}
+	if s.n > len(s.lines) {
+		fmt.Fprintf(&b, "\t... and %d more\n", s.n-len(s.lines))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
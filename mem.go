@@ -0,0 +1,39 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "flag"
+
+var memLimit = flag.Int64("mem", 64<<20, "hold at most about `n` bytes of output in memory (0 for no limit)")
+
+// Limits on what the output scanners keep, regardless of -mem.
+const (
+	maxScanLine = 4096  // longest line passed to the scanners
+	maxDiags    = 10000 // most diagnostics or test failures kept per run
+)
+
+// showLimit returns how many bytes of a run's output to show in the
+// window, or 0 for no limit. It is -spill, lowered if need be to fit
+// -mem: the output shown is held about three times over while the
+// next run is compared against it, so it gets a quarter of the budget.
+func showLimit() int64 {
+	limit := *spillLimit
+	if *memLimit > 0 {
+		if m := *memLimit / 4; limit <= 0 || limit > m {
+			limit = m
+		}
+	}
+	return limit
+}
+
+// lineLimit returns the length past which a line of output
+// without a newline is written to the window as it is,
+// instead of being held until the line is complete.
+func lineLimit() int {
+	if *memLimit <= 0 {
+		return 0
+	}
+	return int(*memLimit / 8)
+}
@@ -0,0 +1,69 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+var fifoPath = flag.String("fifo", "", "also copy output to the named pipe `path`, creating it if needed")
+
+// mirrorc carries output to be copied to the -fifo pipe.
+var mirrorc chan []byte
+
+func openMirror() error {
+	if *fifoPath == "" {
+		return nil
+	}
+	if _, err := os.Stat(*fifoPath); os.IsNotExist(err) {
+		if err := mkfifo(*fifoPath); err != nil {
+			return fmt.Errorf("create fifo: %w", err)
+		}
+	}
+	mirrorc = make(chan []byte, 64)
+	go mirrorLoop()
+	return nil
+}
+
+// mirror copies p to the -fifo pipe, if any.
+// It never blocks: if the reader falls behind, output is dropped.
+func mirror(p []byte) {
+	if mirrorc == nil {
+		return
+	}
+	select {
+	case mirrorc <- append([]byte(nil), p...):
+	default:
+	}
+}
+
+// mirrorf is like mirror but accepts a printf-style formatting.
+func mirrorf(format string, args ...interface{}) {
+	if mirrorc == nil {
+		return
+	}
+	mirror([]byte(fmt.Sprintf(format, args...)))
+}
+
+// mirrorLoop writes output to the pipe, reopening it
+// (and so waiting for a new reader) whenever a write fails.
+func mirrorLoop() {
+	var f *os.File
+	for p := range mirrorc {
+		if f == nil {
+			var err error
+			f, err = os.OpenFile(*fifoPath, os.O_WRONLY, 0)
+			if err != nil {
+				continue
+			}
+		}
+		if _, err := f.Write(p); err != nil {
+			f.Close()
+			f = nil
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin freebsd netbsd openbsd
+
+package main
+
+import "syscall"
+
+// lowPriority lowers the scheduling priority of process pid
+// as far as it will go.
+func lowPriority(pid int) {
+	syscall.Setpriority(syscall.PRIO_PROCESS, pid, 19)
+}
@@ -0,0 +1,109 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"github.com/hherman1/F/watch"
+)
+
+var once = flag.Bool("once", false, "run the command once, without acme, and exit with its exit status")
+var timeout = flag.Duration("timeout", 0, "kill a run lasting longer than `d`")
+
+// Exit statuses for -once, besides the command's own.
+const (
+	exitTimeout  = 124 // the run outlasted -timeout
+	exitSignaled = 125 // the command was killed by a signal not sent by F
+	exitNoStart  = 127 // the command could not be started
+	exitKilled   = 130 // F was interrupted and killed the command
+)
+
+// A printer is the frontend for -once.
+// It copies the output to standard output.
+type printer struct {
+	rec  *record
+	done chan *watch.Run
+}
+
+func (p *printer) Begin(r *watch.Run) {
+	p.rec = newRecord(r)
+	mirrorf("%% %s\n", r.Line)
+}
+
+func (p *printer) Output(r *watch.Run, b []byte) {
+	b = decode(b)
+	p.rec.output.Write(b)
+	os.Stdout.Write(b)
+	mirror(b)
+}
+
+func (p *printer) End(r *watch.Run) {
+	p.rec.finish(r)
+	removeTmp(r)
+	p.done <- r
+}
+
+// runOnce runs the command once and returns the exit status for F.
+func runOnce() int {
+	p := &printer{done: make(chan *watch.Run, 1)}
+	line := strings.Join(args, " ")
+	command := func() (string, error) { return line, nil }
+	if *cmdFile != "" {
+		command = readCmdFile
+	}
+	eng := newEngine(p, command)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		eng.Kill()
+	}()
+	go eng.Run()
+	eng.Kick(watch.Trigger{Kind: "start"})
+	r := <-p.done
+	if r.Err == errUpToDate {
+		fmt.Println("up to date")
+		return 0
+	}
+	if r.Err != nil {
+		fmt.Fprintf(os.Stderr, "F: %v\n", r.Err)
+	}
+	return exitStatus(r)
+}
+
+// exitStatus returns the exit status for F that reports the result of r.
+func exitStatus(r *watch.Run) int {
+	if r.Err == nil {
+		return 0
+	}
+	if !r.Started {
+		if context.Cause(r.Context()) == watch.ErrKilled {
+			return exitKilled
+		}
+		return exitNoStart
+	}
+	switch context.Cause(r.Context()) {
+	case watch.ErrTimeout:
+		return exitTimeout
+	case watch.ErrKilled:
+		return exitKilled
+	}
+	var exit *exec.ExitError
+	if errors.As(r.Err, &exit) {
+		if code := exit.ExitCode(); code >= 0 {
+			return code
+		}
+		return exitSignaled
+	}
+	return 1
+}
@@ -0,0 +1,63 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hherman1/F/watch"
+)
+
+// goTestRE matches the go test commands that Only adds -run to.
+var goTestRE = regexp.MustCompile(`\bgo +test\b`)
+
+// setOnly handles Only with argument arg: "off" stops limiting the
+// tests run, and anything else, or if empty the selection in the
+// window, becomes the pattern passed to -run. Either way, the
+// command is run again.
+func (rn *Runner) setOnly(arg string) {
+	if arg == "" {
+		arg = strings.TrimSpace(rn.win.Selection())
+	}
+	if arg == "" {
+		rn.win.Errf("Only: select a test name or pattern, or execute Only off")
+		return
+	}
+	if arg == "off" {
+		arg = ""
+	}
+	rn.mu.Lock()
+	rn.only = arg
+	rn.mu.Unlock()
+	vlogf("only: %q", arg)
+	rn.eng.Kick(watch.Trigger{Kind: "run"})
+}
+
+// prepareOnly adds -run with the Only pattern, if any,
+// to each go test command in run r. Without one, it leaves
+// run r to -focus.
+func (rn *Runner) prepareOnly(r *watch.Run) {
+	rn.mu.Lock()
+	only := rn.only
+	rn.mu.Unlock()
+	if only == "" {
+		prepareFocus(r)
+		return
+	}
+	r.Line = goTestRE.ReplaceAllLiteralString(r.Line, "go test -run "+rcQuote(only))
+}
+
+// onlyNote returns the note shown above the output
+// while Only is in effect, or "" if it is not.
+func (rn *Runner) onlyNote() string {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if rn.only == "" {
+		return ""
+	}
+	return fmt.Sprintf("(Only %s: running go test -run %s; execute Only off to run all tests)\n", rn.only, rcQuote(rn.only))
+}
@@ -0,0 +1,79 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+var porcelain = flag.Bool("porcelain", false, "print a JSON line to standard output for each trigger, run start, run exit, and run killed")
+
+// porcelainOut is where -porcelain events go.
+var porcelainOut io.Writer = os.Stdout
+
+var porcelainMu sync.Mutex
+
+// An event is a line printed by -porcelain.
+type event struct {
+	Event      string    `json:"event"` // trigger, start, exit, or kill
+	Time       time.Time `json:"time"`
+	Run        int       `json:"run,omitempty"`
+	Trigger    string    `json:"trigger,omitempty"`
+	File       string    `json:"file,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	Duration   float64   `json:"duration,omitempty"` // seconds
+	Error      string    `json:"error,omitempty"`
+	Superseded bool      `json:"superseded,omitempty"`
+}
+
+// emit prints ev, if -porcelain is set.
+func emit(ev event) {
+	if !*porcelain {
+		return
+	}
+	ev.Time = time.Now()
+	js, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	porcelainMu.Lock()
+	defer porcelainMu.Unlock()
+	porcelainOut.Write(append(js, '\n'))
+}
+
+// emitTrigger prints a trigger event for t.
+func emitTrigger(t watch.Trigger) {
+	emit(event{Event: "trigger", Trigger: t.Kind, File: t.File})
+}
+
+// emitExit prints an exit event for run r, described by rec.
+func emitExit(r *watch.Run, rec *record) {
+	ev := event{Event: "exit", Run: r.ID, Trigger: r.Trigger.Kind, Command: rec.Command, Error: rec.Error, Superseded: r.Superseded}
+	if r.Started {
+		code := rec.ExitCode
+		ev.ExitCode = &code
+		ev.Duration = rec.Duration
+	}
+	emit(ev)
+}
+
+// emitKill prints a kill event for run r if it was killed or timed out,
+// however that came about: by Kill, F ctl kill, -cancel-on-edit,
+// -hangkill, or -timeout.
+func emitKill(r *watch.Run) {
+	switch cause := context.Cause(r.Context()); cause {
+	case watch.ErrKilled, watch.ErrTimeout:
+		emit(event{Event: "kill", Run: r.ID, Trigger: r.Trigger.Kind, Error: cause.Error()})
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"sync/atomic"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+var lowPowerFlag = flag.Bool("lowpower", false, "always run as if on battery power")
+
+// lowPowerDelay is the least -delay used on battery power.
+const lowPowerDelay = 2 * time.Second
+
+// onBattery records whether F is in low-power mode.
+var onBattery atomic.Bool
+
+// lowPower reports whether F is in low-power mode, in which runs
+// wait at least lowPowerDelay, run at low priority, and are never
+// speculative, and caches are not warmed.
+func lowPower() bool {
+	return onBattery.Load()
+}
+
+// watchPower puts F in low-power mode while the machine runs on
+// battery, or always with -lowpower, adjusting eng to match.
+// It checks once before returning, and then every 30 seconds.
+func watchPower(eng *watch.Engine) {
+	eng.Started = func(r *watch.Run, pid int) {
+		if lowPower() {
+			lowPriority(pid)
+		}
+	}
+	set := func(low bool) {
+		if low == lowPower() {
+			return
+		}
+		onBattery.Store(low)
+		d := *delay
+		if low && d < lowPowerDelay {
+			d = lowPowerDelay
+		}
+		eng.SetDelay(d)
+		vlogf("low-power mode: %v", low)
+	}
+	if *lowPowerFlag {
+		set(true)
+		return
+	}
+	set(battery())
+	go func() {
+		for {
+			time.Sleep(30 * time.Second)
+			set(battery())
+		}
+	}()
+}
@@ -0,0 +1,35 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// battery reports whether the machine is running on battery power:
+// it has a discharging battery and no AC adapter online.
+func battery() bool {
+	dirs, _ := filepath.Glob("/sys/class/power_supply/*")
+	discharging := false
+	for _, dir := range dirs {
+		read := func(name string) string {
+			b, _ := os.ReadFile(filepath.Join(dir, name))
+			return strings.TrimSpace(string(b))
+		}
+		switch read("type") {
+		case "Mains", "USB":
+			if read("online") == "1" {
+				return false
+			}
+		case "Battery":
+			if read("status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+	return discharging
+}
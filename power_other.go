@@ -0,0 +1,13 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package main
+
+// battery reports whether the machine is running on battery power.
+// Only Linux is supported; elsewhere use -lowpower.
+func battery() bool {
+	return false
+}
@@ -0,0 +1,207 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"9fans.net/go/acme"
+	"github.com/hherman1/F/watch"
+)
+
+var projectsMode = flag.Bool("projects", false, "run F in each directory given as an argument, with the command in its .f file, and summarize them in a +projects window")
+
+// projectGrace is how long the F of each project is given to clean up
+// and exit once interrupted, before it is killed.
+var projectGrace = 5 * time.Second
+
+// A project is a directory with its own F, started by -projects.
+type project struct {
+	dir    string
+	events string // -events file of the project's F
+	cmd    *exec.Cmd
+	exited chan bool
+}
+
+// runProjects starts an F for each directory in args, reading its
+// command from the .f file there, and shows the result of the latest
+// run of each in a summary window, until that window is deleted.
+func runProjects() {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tmp, err := os.MkdirTemp("", "F-projects")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var flags []string
+	for _, a := range os.Args[1 : len(os.Args)-flag.NArg()] {
+		if a == "-projects" || a == "--projects" || strings.HasPrefix(a, "-projects=") || strings.HasPrefix(a, "--projects=") {
+			continue
+		}
+		flags = append(flags, a)
+	}
+	var projects []*project
+	for i, dir := range args {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p := &project{dir: dir, events: filepath.Join(tmp, fmt.Sprintf("%d.json", i)), exited: make(chan bool)}
+		argv := append([]string{"-cmdfile", ".f", "-events", p.events}, flags...)
+		p.cmd = exec.Command(exe, argv...)
+		p.cmd.Dir = dir
+		p.cmd.Stderr = os.Stderr
+		watch.Isolate(p.cmd)
+		if err := p.cmd.Start(); err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			p.cmd.Wait()
+			close(p.exited)
+		}()
+		projects = append(projects, p)
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		stopProjects(projects)
+		os.RemoveAll(tmp)
+		os.Exit(130)
+	}()
+
+	pwd, _ := os.Getwd()
+	w, err := acme.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Name(strings.TrimSuffix(pwd, "/") + "/+projects")
+	w.Ctl("clean")
+	w.Fprintf("tag", "Get ")
+	go func() {
+		for e := range w.EventChan() {
+			if (e.C2 == 'x' || e.C2 == 'X') && string(e.Text) == "Get" {
+				showProjects(w, projects, true)
+				continue
+			}
+			if (e.C2 == 'x' || e.C2 == 'X') && string(e.Text) == "Del" {
+				w.Ctl("delete")
+			}
+			w.WriteEvent(e)
+		}
+		stopProjects(projects)
+		os.RemoveAll(tmp)
+		os.Exit(0)
+	}()
+	for {
+		showProjects(w, projects, false)
+		time.Sleep(time.Second)
+	}
+}
+
+// stopProjects interrupts the F of each project, so that it stops its
+// run, deletes its window, and removes its files, and waits for them
+// to exit, killing any still running after projectGrace.
+func stopProjects(projects []*project) {
+	for _, p := range projects {
+		watch.Interrupt(p.cmd)
+	}
+	deadline := time.Now().Add(projectGrace)
+	for _, p := range projects {
+		select {
+		case <-p.exited:
+		case <-time.After(time.Until(deadline)):
+			watch.Stop(p.cmd)
+			<-p.exited
+		}
+	}
+}
+
+var lastProjects []byte
+
+// showProjects writes a line for each project to the window w,
+// if anything has changed or force is set.
+func showProjects(w *acme.Win, projects []*project, force bool) {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	failed := 0
+	for _, p := range projects {
+		status, detail := p.status()
+		if status == "FAIL" || status == "exited" {
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", status, p.dir, detail)
+	}
+	tw.Flush()
+	fmt.Fprintf(&buf, "\n%d of %d projects failing\n", failed, len(projects))
+	if !force && bytes.Equal(buf.Bytes(), lastProjects) {
+		return
+	}
+	lastProjects = buf.Bytes()
+	w.Addr(",")
+	w.Write("data", buf.Bytes())
+	w.Ctl("clean")
+}
+
+// status returns the project's status, "ok", "FAIL", "-" if it
+// has not yet finished a run, or "exited", and a description of
+// its latest run.
+func (p *project) status() (status, detail string) {
+	select {
+	case <-p.exited:
+		return "exited", ""
+	default:
+	}
+	rec, ok := lastRecord(p.events)
+	if !ok {
+		return "-", ""
+	}
+	status = "ok"
+	if rec.ExitCode != 0 || rec.Error != "" {
+		status = "FAIL"
+	}
+	detail = fmt.Sprintf("%s\t%.1fs\t%s", rec.Command, rec.Duration, rec.Start.Format("15:04:05"))
+	return status, detail
+}
+
+// lastRecord returns the last record in the -events file name.
+// Only the end of the file is read, as the file grows with every run.
+func lastRecord(name string) (rec record, ok bool) {
+	f, err := os.Open(name)
+	if err != nil {
+		return rec, false
+	}
+	defer f.Close()
+	const maxRecord = 64 << 10 // more than any JSON record
+	if info, err := f.Stat(); err == nil && info.Size() > maxRecord {
+		f.Seek(info.Size()-maxRecord, io.SeekStart)
+	}
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var r record
+		if json.Unmarshal(s.Bytes(), &r) == nil {
+			rec, ok = r, true
+		}
+	}
+	return rec, ok
+}
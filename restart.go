@@ -0,0 +1,99 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"9fans.net/go/acme"
+	"github.com/hherman1/F/watch"
+)
+
+var restartOnce sync.Once
+
+// acmeGone reports whether the acme server has gone away,
+// as opposed to just F's window having been deleted.
+func acmeGone() bool {
+	_, err := acme.Windows()
+	return err != nil
+}
+
+// restart waits for acme to come back and then reexecutes F
+// with the command most recently run by eng, so that the watch resumes
+// in a fresh window. The acme package cannot remount a server
+// once its connection is lost, so starting over is the only way
+// to reattach. Restart never returns.
+func restart(eng *watch.Engine) {
+	restartOnce.Do(func() {
+		log.Print("acme has exited; waiting for it to restart")
+		eng.Kill()
+		waitAcme()
+		reexecLine(eng)
+	})
+	select {}
+}
+
+// waitAcme waits for acme to be listening again.
+func waitAcme() {
+	service := *acmeAddr
+	if service == "" {
+		service = "acme"
+	}
+	network, addr, err := parseDial(service)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		c, err := net.Dial(network, addr)
+		if err == nil {
+			c.Close()
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	// Give acme a moment to finish starting.
+	time.Sleep(500 * time.Millisecond)
+}
+
+// reexecLine reexecutes F with the command most recently run by eng.
+func reexecLine(eng *watch.Engine) {
+	if err := reexec(restartArgs(eng.Line())); err != nil {
+		log.Fatalf("restart: %v", err)
+	}
+}
+
+// restartArgs returns the arguments with which to reexecute F so that
+// it runs line, or, if F takes its command from a file, that file.
+// The file is passed as -cmdfile even when F defaulted to .f,
+// since line would otherwise stand in for it.
+func restartArgs(line string) []string {
+	argv := append([]string{}, os.Args[:len(os.Args)-flag.NArg()]...)
+	if *cmdFile != "" {
+		return append(argv, "-cmdfile="+*cmdFile)
+	}
+	if line == "" {
+		return append(argv, strings.Join(args, " "))
+	}
+	return append(argv, cmdArg(line))
+}
+
+// cmdArg returns the argument that puts line, which holds the commands
+// of a tag as parseCmd returns them, back in a tag: each command after
+// the first goes on a line of its own beginning with %.
+func cmdArg(line string) string {
+	var cmds []string
+	for line != "" {
+		var cmd string
+		cmd, line = cutCmd(line)
+		cmds = append(cmds, cmd)
+	}
+	return strings.Join(cmds, "\n% ")
+}
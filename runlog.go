@@ -0,0 +1,151 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+var eventsFile = flag.String("events", "", "append a JSON record of each run to `file`")
+
+// maxRecordOutput is the number of trailing output bytes kept in a record.
+const maxRecordOutput = 8192
+
+var eventLog struct {
+	sync.Mutex
+	f *os.File
+}
+
+// A record is the JSON object written to the -events file for each run.
+type record struct {
+	Start     time.Time  `json:"start"`
+	Trigger   string     `json:"trigger"`
+	File      string     `json:"file,omitempty"`
+	Command   string     `json:"command"`
+	ExitCode  int        `json:"exit_code"`
+	Error     string     `json:"error,omitempty"`
+	Duration  float64    `json:"duration"` // seconds
+	Output    string     `json:"output"`
+	Truncated bool       `json:"truncated,omitempty"`
+	Steps     []stepTime `json:"steps,omitempty"`
+	Stopped   string     `json:"stopped,omitempty"` // killed, superseded, or timed out, if it did not end on its own
+
+	output tail
+}
+
+func openEvents() error {
+	if *eventsFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(*eventsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	eventLog.f = f
+	return nil
+}
+
+func newRecord(r *watch.Run) *record {
+	return &record{
+		Start:   r.Start,
+		Trigger: r.Trigger.Kind,
+		File:    r.Trigger.File,
+		Command: r.Line,
+		output:  tail{max: maxRecordOutput},
+	}
+}
+
+// finish completes the record with the result of r
+// and appends it to the -events file, if any.
+func (rec *record) finish(r *watch.Run) {
+	rec.Duration = r.End.Sub(rec.Start).Seconds()
+	rec.ExitCode = exitCode(r.Err)
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	switch cause := context.Cause(r.Context()); cause {
+	case watch.ErrKilled, watch.ErrSuperseded, watch.ErrTimeout:
+		rec.Stopped = cause.Error()
+	}
+	rec.Output = string(rec.output.buf)
+	rec.Truncated = rec.output.dropped
+	if eventLog.f == nil {
+		return
+	}
+	js, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	eventLog.Lock()
+	eventLog.f.Write(append(js, '\n'))
+	eventLog.Unlock()
+}
+
+// exitCode returns the exit status of a command that ended with err,
+// or -1 if it did not exit normally.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode()
+	}
+	return -1
+}
+
+// A tail keeps the last max bytes written to it.
+type tail struct {
+	max     int
+	buf     []byte
+	dropped bool
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max:]...)
+		t.dropped = true
+	}
+	return len(p), nil
+}
+
+// A lineSplitter calls fn for each complete line written to it,
+// without the trailing newline. Lines are cut short at maxScanLine bytes.
+type lineSplitter struct {
+	fn      func(line string)
+	partial []byte
+}
+
+func (s *lineSplitter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		end := i
+		if i < 0 {
+			end = len(p)
+		}
+		if room := maxScanLine - len(s.partial); room > 0 {
+			s.partial = append(s.partial, p[:min(end, room)]...)
+		}
+		if i < 0 {
+			break
+		}
+		s.fn(string(s.partial))
+		s.partial = s.partial[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
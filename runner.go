@@ -0,0 +1,556 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+// A window is the part of an acme window used by a Runner.
+// It is implemented by *acme.Win.
+type window interface {
+	Addr(format string, args ...interface{}) error
+	Ctl(format string, args ...interface{}) error
+	Fprintf(file, format string, args ...interface{}) error
+	ReadAll(file string) ([]byte, error)
+	Write(file string, b []byte) (int, error)
+	Errf(format string, args ...interface{})
+	Selection() string
+}
+
+// A Runner runs the command in a window's tag each time it is
+// triggered, showing each run's output in the window body.
+// It is the engine's frontend for the window.
+type Runner struct {
+	win   window
+	eng   *watch.Engine
+	winid int // acme ID of win, if known
+
+	// Used only from the engine's frontend goroutine.
+	out      view
+	states   map[int]*runState // runs begun but not ended
+	curSpool *spool            // spool of the run most recently begun
+	fold     *folder           // folded output of the last completed run
+	warm     warmer
+
+	mu      sync.Mutex
+	fails   []testFailure // Go test failures in the last completed run
+	runs    []runStat     // completed runs, for Stats
+	hist    []record      // recent completed runs, including earlier sessions
+	cmd     string        // command in the tag, as of the latest tag edit
+	lastCmd string        // command of the run most recently begun
+	compare string        // command to compare with, if any
+	only    string        // pattern for go test -run, set by Only
+	inst    *instance     // registration for F list, if any; used only from the frontend goroutine
+	// unattended is set once acme has gone and F carries on, with -daemon.
+	unattended bool
+	// comparing holds the comparisons of runs prepared but not ended, by run ID.
+	comparing map[int]*comparison
+	// stepping holds the step plans of runs prepared but not ended, by run ID.
+	stepping map[int]*stepPlan
+}
+
+// A runState is what the Runner tracks for a run in progress.
+type runState struct {
+	sp    *spool
+	bol   bool // output shown so far ends with a newline (or is empty)
+	rec   *record
+	fails failScanner
+	diags diagScanner
+	match matchScanner
+	lines *lineSplitter
+	fold  *folder     // if -fold
+	cmp   *comparison // if comparing
+	steps *stepPlan   // if running the commands as steps
+	// stopHang cancels the -hang SIGQUIT.
+	stopHang func()
+}
+
+func newRunner(w window) *Runner {
+	rn := &Runner{
+		win:       w,
+		states:    make(map[int]*runState),
+		comparing: make(map[int]*comparison),
+		stepping:  make(map[int]*stepPlan),
+	}
+	rn.eng = newEngine(rn, rn.command)
+	rn.eng.Prepare = rn.prepare
+	rn.eng.Recover = rn.recovered
+	rn.eng.Discard = rn.discard
+	if *porcelain {
+		rn.eng.Kicked = emitTrigger
+	}
+	rn.out.win = w
+	rn.out.eng = rn.eng
+	if tag, err := w.ReadAll("tag"); err == nil {
+		rn.cmd = parseCmd(string(tag))
+	}
+	hist, err := loadHistory()
+	if err != nil {
+		log.Printf("load history: %v", err)
+	}
+	rn.hist = hist
+	return rn
+}
+
+// discard releases what was prepared for run r,
+// which was superseded before it began.
+func (rn *Runner) discard(r *watch.Run) {
+	removeTmp(r)
+	rn.mu.Lock()
+	delete(rn.comparing, r.ID)
+	delete(rn.stepping, r.ID)
+	rn.mu.Unlock()
+}
+
+// recovered reports a panic from which the engine recovered:
+// in the window, so that it is seen, and with its stack in the log.
+func (rn *Runner) recovered(where string, v interface{}, stack []byte) {
+	log.Printf("panic in %s: %v\n%s", where, v, stack)
+	rn.report("internal error in %s: %v (see F's standard error for the stack); still watching", where, v)
+}
+
+// report adds a line describing a problem to the end of the window body,
+// and logs it. The line stays until the next run replaces the output.
+func (rn *Runner) report(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	go rn.eng.Post(nil, func() {
+		rn.out.flush()
+		if rn.win.Addr("$") == nil {
+			rn.win.Write("data", []byte("F: "+msg+"\n"))
+		}
+	})
+}
+
+// history returns the recent completed runs, oldest first,
+// including those of earlier sessions in the same directory.
+func (rn *Runner) history() []record {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return append([]record(nil), rn.hist...)
+}
+
+// shell returns the shell to run commands with: -shell, or else rc.
+func shell() string {
+	if *shellFlag != "" {
+		return *shellFlag
+	}
+	return watch.RC()
+}
+
+// newEngine returns an engine for fe and command, set up by F's flags.
+func newEngine(fe watch.Frontend, command func() (string, error)) *watch.Engine {
+	eng := watch.New(fe, command)
+	eng.Shell = shell()
+	eng.Prepare = prepare
+	eng.Discard = removeTmp
+	eng.StormRate = *stormRate
+	eng.Delay = *delay
+	eng.Timeout = *timeout
+	if *verbose {
+		eng.Logf = vlogf
+	}
+	return eng
+}
+
+// parseCmd returns the command in the tag text tag: everything after
+// the first % to the end of the line, followed by the commands on any
+// later lines beginning with %, one per line. Commands beginning with #
+// are left out. A line ending in a backslash continues onto the next;
+// the backslashes and newlines are passed on to the shell.
+func parseCmd(tag string) string {
+	_, rest, ok := strings.Cut(tag, "%")
+	if !ok {
+		return ""
+	}
+	var cmds []string
+	for {
+		cmd, after := cutCmd(rest)
+		if cmd != "" && !strings.HasPrefix(cmd, "#") {
+			cmds = append(cmds, cmd)
+		}
+		rest = ""
+		for after != "" {
+			var line string
+			line, after, _ = strings.Cut(after, "\n")
+			if l := strings.TrimLeft(line, " \t"); strings.HasPrefix(l, "%") {
+				rest = l[1:] + "\n" + after
+				break
+			}
+		}
+		if rest == "" {
+			return strings.Join(cmds, "\n")
+		}
+	}
+}
+
+// cutCmd returns the command at the start of s, which runs to the end
+// of the line, or further if the line ends in a backslash, and the text
+// after the command's last line.
+func cutCmd(s string) (cmd, after string) {
+	end := 0
+	for {
+		i := strings.IndexByte(s[end:], '\n')
+		if i < 0 {
+			return strings.TrimSpace(s), ""
+		}
+		end += i
+		if !strings.HasSuffix(strings.TrimRight(s[:end], " \t"), "\\") {
+			return strings.TrimSpace(s[:end]), s[end+1:]
+		}
+		end++
+	}
+}
+
+// command returns the command for a new run. It is the command
+// as of the latest tag edit, not as the tag stands now, so that
+// a run always uses a command the Runner has seen.
+func (rn *Runner) command() (string, error) {
+	if *cmdFile != "" {
+		return readCmdFile()
+	}
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return rn.cmd, nil
+}
+
+// prepare readies run r, or returns errUpToDate to skip it.
+func prepare(r *watch.Run) error {
+	if err := checkFresh(r); err != nil {
+		return err
+	}
+	r.Env = append(r.Env, fmt.Sprintf("F_RUN_ID=%d", r.ID), "F_TRIGGER="+r.Trigger.Kind)
+	expandArg(r)
+	if err := prepareTmp(r); err != nil {
+		return err
+	}
+	if *stagedMode {
+		return prepareStaged(r)
+	}
+	return nil
+}
+
+// prepare readies run r of the Runner's command.
+func (rn *Runner) prepare(r *watch.Run) error {
+	rn.prepareCompare(r)
+	rn.prepareOnly(r)
+	rn.prepareSteps(r)
+	rn.acmeEnv(r)
+	rn.mu.Lock()
+	if n := len(rn.hist); n > 0 {
+		r.Env = append(r.Env, fmt.Sprintf("F_PREV_STATUS=%d", rn.hist[n-1].ExitCode))
+	}
+	rn.mu.Unlock()
+	return prepare(r)
+}
+
+// acmeEnv passes the acme context of run r to the command:
+// the ID of the Runner's window as $winid, and the ID and name
+// of the window whose Put triggered r, if any, as $F_WINID and $%.
+func (rn *Runner) acmeEnv(r *watch.Run) {
+	if rn.winid != 0 {
+		r.Env = append(r.Env, fmt.Sprintf("winid=%d", rn.winid))
+	}
+	if r.Trigger.Win != 0 {
+		r.Env = append(r.Env, fmt.Sprintf("F_WINID=%d", r.Trigger.Win), "%="+r.Trigger.File)
+	}
+}
+
+// expandArg passes the argument of run r's trigger, if any,
+// to the command as $ARG and in place of {}.
+// Without an argument, {} is left alone, as find -exec needs it.
+func expandArg(r *watch.Run) {
+	if r.Trigger.Arg == "" {
+		return
+	}
+	r.Line = strings.ReplaceAll(r.Line, "{}", rcQuote(r.Trigger.Arg))
+	r.Env = append(r.Env, "ARG="+r.Trigger.Arg)
+}
+
+// tagEdited handles an edit to the window's tag.
+// If the edit changed the command, it marks the window as
+// modified and triggers a run of the new command.
+// Edits elsewhere in the tag are ignored.
+func (rn *Runner) tagEdited() error {
+	if *cmdFile != "" {
+		vlogf("tag edit: the command comes from %s: ignored", *cmdFile)
+		return nil
+	}
+	tag, err := rn.win.ReadAll("tag")
+	if err != nil {
+		return fmt.Errorf("read tag: %w", err)
+	}
+	cmd := parseCmd(string(tag))
+	rn.mu.Lock()
+	if cmd == rn.cmd {
+		rn.mu.Unlock()
+		vlogf("tag edit outside the command: ignored")
+		return nil
+	}
+	rn.cmd = cmd
+	rn.mark()
+	rn.mu.Unlock()
+	rn.eng.Kick(watch.Trigger{Kind: "tag"})
+	return nil
+}
+
+// mark shows whether the command in the tag differs from the one
+// last run, by marking the window dirty or clean.
+// The shared +Errors window is left alone.
+// rn.mu must be held, so that marks are made in order.
+func (rn *Runner) mark() {
+	if *errorsMode {
+		return
+	}
+	if rn.cmd != rn.lastCmd {
+		rn.win.Ctl("dirty")
+	} else {
+		rn.win.Ctl("clean")
+	}
+}
+
+// execute handles the command cmd, with argument arg, executed in the
+// window. Words after the first in cmd, as in "Only off", are taken to
+// precede arg. It reports whether cmd was one of the Runner's commands.
+func (rn *Runner) execute(cmd, arg string) bool {
+	if name, rest, ok := strings.Cut(strings.TrimSpace(cmd), " "); ok {
+		cmd, arg = name, strings.TrimSpace(rest+" "+arg)
+	}
+	switch cmd {
+	case "Run":
+		rn.eng.Kick(watch.Trigger{Kind: "run", Arg: arg})
+	case "Kill":
+		rn.eng.Kill()
+	case "Quit":
+		if err := rn.eng.Quit(); err != nil {
+			rn.win.Errf("Quit: %v; use Kill", err)
+		}
+	case "Debug":
+		go rn.debug(arg)
+	case "Stats":
+		go rn.stats()
+	case "Compare":
+		rn.setCompare(arg)
+	case "Only":
+		rn.setOnly(arg)
+	case "More":
+		rn.eng.Post(nil, func() { rn.curSpool.more(rn.win, rn.out.alert) })
+	default:
+		return false
+	}
+	return true
+}
+
+// toggle opens or closes the package section whose header
+// is at rune offset q in the body, reporting whether there is one.
+func (rn *Runner) toggle(q int) bool {
+	done := make(chan bool, 1)
+	rn.eng.Post(nil, func() {
+		done <- *foldTests && len(rn.states) == 0 && rn.fold != nil && rn.fold.toggle(rn.win, q, rn.out.alert)
+	})
+	select {
+	case ok := <-done:
+		return ok
+	case <-rn.eng.Done():
+		return false
+	}
+}
+
+// close removes the Runner's temporary files and instance file.
+func (rn *Runner) close() {
+	done := make(chan bool)
+	rn.eng.Post(nil, func() {
+		rn.curSpool.remove()
+		rn.warm.stop(true)
+		rn.unregister()
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
+
+func (rn *Runner) Begin(r *watch.Run) {
+	st := &runState{sp: newSpool(), bol: true, rec: newRecord(r)}
+	st.lines = &lineSplitter{fn: func(l string) {
+		st.fails.line(l)
+		st.diags.line(l)
+		st.match.line(l)
+	}}
+	if *foldTests && !*errorsMode {
+		st.fold = new(folder)
+	}
+	rn.states[r.ID] = st
+	st.stopHang = rn.watchHang(r)
+	rn.warm.stop(false)
+	rn.fold = nil
+	rn.curSpool.remove()
+	rn.curSpool = st.sp
+
+	rn.mu.Lock()
+	st.cmp = rn.comparing[r.ID]
+	st.steps = rn.stepping[r.ID]
+	line := r.Line
+	if st.cmp != nil {
+		line = st.cmp.a
+	}
+	if st.steps != nil {
+		line = st.steps.line
+		st.steps.last = time.Now()
+	}
+	st.rec.Command = line // not the script run for it
+	rn.lastCmd = line
+	rn.mark()
+	rn.mu.Unlock()
+	rn.instanceBegan(line)
+
+	emit(event{Event: "start", Run: r.ID, Trigger: r.Trigger.Kind, Command: line})
+	rn.out.begin(r)
+	if *errorsMode {
+		rn.out.printf("%% %s\n", line)
+	}
+	mirrorf("%% %s\n", line)
+}
+
+func (rn *Runner) Output(r *watch.Run, p []byte) {
+	p = decode(p)
+	st := rn.states[r.ID]
+	if st.steps != nil {
+		p = st.steps.filter(p)
+	}
+	rn.add(st, p)
+}
+
+// add adds p to the output of the run with state st.
+func (rn *Runner) add(st *runState, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	st.rec.output.Write(p)
+	mirror(p)
+	if st.cmp != nil {
+		st.cmp.write(p)
+		return
+	}
+	st.lines.Write(p)
+	if show := st.sp.add(p); len(show) > 0 {
+		if st.fold != nil {
+			st.fold.write(show, &rn.out)
+		} else {
+			rn.out.write(show)
+		}
+		st.bol = show[len(show)-1] == '\n'
+	}
+}
+
+func (rn *Runner) End(r *watch.Run) {
+	defer removeTmp(r)
+	st := rn.states[r.ID]
+	if st.steps != nil {
+		rn.add(st, st.steps.flush())
+		st.steps.end(r)
+		st.rec.Steps = st.steps.times
+	}
+	delete(rn.states, r.ID)
+	st.stopHang()
+	rn.mu.Lock()
+	delete(rn.comparing, r.ID)
+	delete(rn.stepping, r.ID)
+	rn.mu.Unlock()
+	st.rec.finish(r)
+	emitKill(r)
+	emitExit(r, st.rec)
+	ended := st.rec
+	if r.Superseded || r.Err == errUpToDate {
+		ended = nil
+	}
+	defer rn.instanceEnded(ended)
+	if r.Superseded {
+		return
+	}
+	out := &rn.out
+	if r.Err == errUpToDate {
+		out.printf("up to date\n")
+		out.end()
+		mirrorf("up to date\n")
+		return
+	}
+	rn.mu.Lock()
+	rn.runs = append(rn.runs, runStat{r.Start, r.End.Sub(r.Start), r.Err != nil, st.rec.Command})
+	slow := ""
+	if r.Started && st.rec.Stopped == "" {
+		slow = slowNote(rn.hist, st.rec.Command, r.End.Sub(r.Start))
+	}
+	rn.hist = append(rn.hist, *st.rec)
+	if len(rn.hist) > maxHistory {
+		rn.hist = rn.hist[len(rn.hist)-maxHistory:]
+	}
+	rn.mu.Unlock()
+	if err := saveHistory(st.rec); err != nil {
+		vlogf("save history: %v", err)
+	}
+	rn.ended(st.rec)
+	if !r.Started {
+		out.printf("(%v)\n", r.Err)
+		out.end()
+		mirrorf("(%v)\n", r.Err)
+		return
+	}
+	if st.cmp != nil {
+		st.cmp.show(out)
+		if r.Err != nil {
+			out.printf("(%v)\n", r.Err)
+		}
+		out.end()
+		return
+	}
+	writeQuickfix(st.diags.diags)
+	rn.mu.Lock()
+	rn.fails = st.fails.fails
+	rn.mu.Unlock()
+
+	if st.fold != nil {
+		st.fold.end(out)
+		rn.fold = st.fold
+	}
+	st.bol = st.sp.finish(out, st.bol)
+	// If output was missing final newline, print trailing backslash and add newline.
+	if !st.bol {
+		out.printf("\\\n")
+		mirrorf("\\\n")
+	}
+	if r.Err != nil {
+		out.printf("(%v)\n", r.Err)
+		mirrorf("(%v)\n", r.Err)
+	}
+	out.end()
+	if s := st.match.section(); s != "" {
+		out.showAlert(s)
+		mirrorf("%s", s)
+	}
+	if slow != "" {
+		out.showAlert(slow)
+		mirrorf("%s", slow)
+	}
+	if st.steps != nil {
+		if s := st.steps.note(); s != "" {
+			out.showAlert(s)
+			mirrorf("%s", s)
+		}
+	}
+	if s := rn.onlyNote(); s != "" {
+		out.showAlert(s)
+	}
+	placeDot(rn.win, rn.out.alert)
+	rn.warm.start(rn)
+}
@@ -0,0 +1,1419 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "F-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	*stateDirFlag = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// A fakeWin is an in-memory window.
+type fakeWin struct {
+	mu     sync.Mutex
+	tag    string
+	body   []rune
+	q0, q1 int
+	writes int // writes to data
+	errs   []string
+	ctls   []string
+	badCtl string // prefix of ctl messages to reject
+}
+
+func (w *fakeWin) Addr(format string, args ...interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	addr := fmt.Sprintf(format, args...)
+	switch addr {
+	case "$":
+		w.q0, w.q1 = len(w.body), len(w.body)
+		return nil
+	case ",":
+		w.q0, w.q1 = 0, len(w.body)
+		return nil
+	}
+	a, b, ok := strings.Cut(addr, ",")
+	q0, err := strconv.Atoi(strings.TrimPrefix(a, "#"))
+	if err != nil {
+		return fmt.Errorf("bad address %q", addr)
+	}
+	q1 := q0
+	if ok {
+		if q1, err = strconv.Atoi(strings.TrimPrefix(b, "#")); err != nil {
+			return fmt.Errorf("bad address %q", addr)
+		}
+	}
+	if q0 < 0 || q1 < q0 || q1 > len(w.body) {
+		return fmt.Errorf("address %q out of range", addr)
+	}
+	w.q0, w.q1 = q0, q1
+	return nil
+}
+
+func (w *fakeWin) Selection() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return string(w.body[w.q0:w.q1])
+}
+
+func (w *fakeWin) Ctl(format string, args ...interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	msg := fmt.Sprintf(format, args...)
+	if w.badCtl != "" && strings.HasPrefix(msg, w.badCtl) {
+		return fmt.Errorf("bad ctl message")
+	}
+	w.ctls = append(w.ctls, msg)
+	if msg == "cleartag" {
+		w.tag = ""
+	}
+	return nil
+}
+
+func (w *fakeWin) Fprintf(file, format string, args ...interface{}) error {
+	_, err := w.Write(file, []byte(fmt.Sprintf(format, args...)))
+	return err
+}
+
+func (w *fakeWin) ReadAll(file string) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch file {
+	case "tag":
+		return []byte(w.tag), nil
+	case "body":
+		return []byte(string(w.body)), nil
+	}
+	return nil, fmt.Errorf("no file %s", file)
+}
+
+func (w *fakeWin) Write(file string, b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch file {
+	case "tag":
+		w.tag += string(b)
+	case "data":
+		text := []rune(string(b))
+		body := append([]rune{}, w.body[:w.q0]...)
+		body = append(body, text...)
+		w.body = append(body, w.body[w.q1:]...)
+		w.q0 += len(text)
+		w.q1 = w.q0
+		w.writes++
+	default:
+		return 0, fmt.Errorf("no file %s", file)
+	}
+	return len(b), nil
+}
+
+func (w *fakeWin) Errf(format string, args ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errs = append(w.errs, fmt.Sprintf(format, args...))
+}
+
+func (w *fakeWin) setCmd(cmd string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.tag = "Kill Quit Debug % " + cmd
+}
+
+func (w *fakeWin) text() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return string(w.body)
+}
+
+// waitBody waits for the body of w to satisfy ok.
+func waitBody(t *testing.T, w *fakeWin, ok func(body string) bool) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		body := w.text()
+		if ok(body) {
+			return body
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out; body is %q", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func equals(want string) func(string) bool {
+	return func(body string) bool { return body == want }
+}
+
+// set sets *p to v until t finishes. Tests call it before newTestRunner,
+// so the runner's engine is stopped before the old value is put back.
+func set[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func newTestRunner(t *testing.T, cmd string) (*Runner, *fakeWin) {
+	w := new(fakeWin)
+	w.setCmd(cmd)
+	rn := newRunner(w)
+	rn.eng.Shell = "/bin/sh"
+	t.Cleanup(rn.eng.Stop)
+	return rn, w
+}
+
+func TestRun(t *testing.T) {
+	rn, w := newTestRunner(t, "echo hello; echo world")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("hello\nworld\n"))
+}
+
+func TestRunFailure(t *testing.T) {
+	rn, w := newTestRunner(t, "printf partial; exit 3")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("partial\\\n(exit status 3)\n"))
+}
+
+func TestKill(t *testing.T) {
+	rn, w := newTestRunner(t, "echo started; sleep 10; echo finished")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("started\n"))
+	if !rn.execute("Kill", "") {
+		t.Fatal("Kill not handled")
+	}
+	body := waitBody(t, w, func(body string) bool { return strings.Contains(body, "(signal: ") })
+	if strings.Contains(body, "finished") {
+		t.Errorf("killed command kept running: %q", body)
+	}
+}
+
+func TestHang(t *testing.T) {
+	set(t, hangAfter, 200*time.Millisecond)
+	set(t, hangKill, true)
+	rn, w := newTestRunner(t, "trap 'echo dumped' QUIT; sleep 10 & wait; sleep 10")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	body := waitBody(t, w, func(body string) bool { return strings.Contains(body, "(signal: ") })
+	if want := "(still running after 200ms; sending SIGQUIT for a goroutine dump)\ndumped\n"; !strings.HasPrefix(body, want) {
+		t.Errorf("body %q, want prefix %q", body, want)
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	rn, w := newTestRunner(t, "echo one; sleep 1; echo late")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("one\n"))
+	w.setCmd("echo two")
+	rn.tagEdited()
+	waitBody(t, w, equals("two\n"))
+	// The first run must not write into the second's output,
+	// even if it manages to print before it is killed.
+	time.Sleep(1500 * time.Millisecond)
+	if body := w.text(); body != "two\n" {
+		t.Errorf("body = %q, want %q", body, "two\n")
+	}
+}
+
+func TestTriggersCoalesce(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	rn, w := newTestRunner(t, "echo x >>"+count+"; wc -l <"+count+" | tr -d ' '")
+	for i := 0; i < 10; i++ {
+		rn.eng.Kick(watch.Trigger{Kind: "put"})
+	}
+	go rn.eng.Run()
+	waitBody(t, w, equals("1\n"))
+	time.Sleep(200 * time.Millisecond)
+	if body := w.text(); body != "1\n" {
+		t.Errorf("ran more than once: body = %q", body)
+	}
+}
+
+func TestUnchangedLinesKept(t *testing.T) {
+	rn, w := newTestRunner(t, "printf 'a\\nB\\nc\\n'")
+	w.body = []rune("a\nb\nc\n")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("a\nB\nc\n"))
+	time.Sleep(2 * flushDelay)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.writes != 2 {
+		t.Errorf("made %d writes, want 2 (insert B, delete b)", w.writes)
+	}
+}
+
+func TestIdenticalOutputUntouched(t *testing.T) {
+	rn, w := newTestRunner(t, "printf 'a\\nb\\n'; exit 1")
+	w.body = []rune("a\nb\n(exit status 1)\n")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	ended := func() bool {
+		rn.mu.Lock()
+		defer rn.mu.Unlock()
+		return len(rn.runs) > 0
+	}
+	for deadline := time.Now().Add(5 * time.Second); !ended(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("run did not end")
+		}
+	}
+	time.Sleep(2 * flushDelay)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.writes != 0 {
+		t.Errorf("made %d writes for identical output, want 0", w.writes)
+	}
+	if body := string(w.body); body != "a\nb\n(exit status 1)\n" {
+		t.Errorf("body is %q", body)
+	}
+}
+
+func TestIdenticalOutputSlow(t *testing.T) {
+	// Slower than bannerDelay: only the banner is written.
+	rn, w := newTestRunner(t, "sleep 0.5; printf 'a\\nb\\n'; exit 1")
+	w.body = []rune("a\nb\n(exit status 1)\n")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals(banner+"a\nb\n(exit status 1)\n"))
+	waitBody(t, w, equals("a\nb\n(exit status 1)\n"))
+	time.Sleep(2 * flushDelay)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.writes != 2 {
+		t.Errorf("made %d writes for identical output, want 2, placing and removing the banner", w.writes)
+	}
+}
+
+func TestDotError(t *testing.T) {
+	set(t, dotPlace, "error")
+	rn, w := newTestRunner(t, "echo ok; echo x.go:3: bad")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	if q0 := waitDot(t, w); q0 != 3 {
+		t.Errorf("dot at #%d, want #3", q0)
+	}
+}
+
+func TestDotErrorAfterMatch(t *testing.T) {
+	// The error listed by -match above the output is passed over.
+	set(t, dotPlace, "error")
+	set(t, &matchRE, regexp.MustCompile("bad"))
+	rn, w := newTestRunner(t, "echo ok; echo x.go:3: bad")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	alert := "1 line matches bad:\n\tx.go:3: bad\n\n"
+	q0 := waitDot(t, w)
+	waitBody(t, w, equals(alert+"ok\nx.go:3: bad\n"))
+	if want := len(alert) + 3; q0 != want {
+		t.Errorf("dot at #%d, want #%d", q0, want)
+	}
+}
+
+// waitDot waits for dot to be placed in w and returns its offset.
+func waitDot(t *testing.T, w *fakeWin) int {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		w.mu.Lock()
+		n, q0 := len(w.ctls), w.q0
+		done := n >= 2 && w.ctls[n-2] == "dot=addr" && w.ctls[n-1] == "show"
+		w.mu.Unlock()
+		if done {
+			return q0
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dot not placed")
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	set(t, &matchRE, regexp.MustCompile("WARN|TODO"))
+	rn, w := newTestRunner(t, "echo a; echo WARN b; echo c; echo TODO d")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("2 lines match WARN|TODO:\n\tWARN b\n\tTODO d\n\na\nWARN b\nc\nTODO d\n"))
+}
+
+func TestPorcelain(t *testing.T) {
+	var buf bytes.Buffer
+	set(t, porcelain, true)
+	set(t, &porcelainOut, io.Writer(&buf))
+	rn, w := newTestRunner(t, "exit 2")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("(exit status 2)\n"))
+	if got, want := porcelainEvents(t, &buf), []string{"trigger start", "start start", "exit start 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events %q, want %q", got, want)
+	}
+}
+
+func TestPorcelainKill(t *testing.T) {
+	var buf bytes.Buffer
+	set(t, porcelain, true)
+	set(t, &porcelainOut, io.Writer(&buf))
+	rn, w := newTestRunner(t, "echo going; sleep 10")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("going\n"))
+	rn.eng.Kill() // as by F ctl kill or -cancel-on-edit
+	waitBody(t, w, equals("going\n(signal: interrupt)\n"))
+	ended := make(chan bool)
+	rn.eng.Post(nil, func() { close(ended) })
+	<-ended
+	want := []string{"trigger start", "start start", "kill start killed", "exit start -1"}
+	if got := porcelainEvents(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("events %q, want %q", got, want)
+	}
+}
+
+// porcelainEvents returns a summary of each event written to buf.
+func porcelainEvents(t *testing.T, buf *bytes.Buffer) []string {
+	porcelainMu.Lock()
+	defer porcelainMu.Unlock()
+	var got []string
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var ev event
+		if err := json.Unmarshal([]byte(l), &ev); err != nil {
+			t.Fatalf("bad event %q: %v", l, err)
+		}
+		s := ev.Event + " " + ev.Trigger
+		if ev.Event == "kill" {
+			s += " " + ev.Error
+		}
+		if ev.ExitCode != nil {
+			s += fmt.Sprintf(" %d", *ev.ExitCode)
+		}
+		got = append(got, s)
+	}
+	return got
+}
+
+func TestParseCmd(t *testing.T) {
+	for _, tt := range []struct{ tag, cmd string }{
+		{"Run Kill % go test", "go test"},
+		{"Run Kill Quit", ""},
+		{"Run % go test\nnotes", "go test"},
+		{"Run % GOFLAGS=-v \\\n\tgo test \\ \n\t| grep ok\nnotes", "GOFLAGS=-v \\\n\tgo test \\ \n\t| grep ok"},
+		{"Run % go test \\\n", "go test \\"},
+		{"Run % go vet\n% go test\n  %go build", "go vet\ngo test\ngo build"},
+		{"Run %# go vet\n% #go test -race \\\n\t./...\n% go test", "go test"},
+		{"Run % date +%s\nsee %x", "date +%s"},
+		{"Run %# go vet", ""},
+	} {
+		if cmd := parseCmd(tt.tag); cmd != tt.cmd {
+			t.Errorf("parseCmd(%q) = %q, want %q", tt.tag, cmd, tt.cmd)
+		}
+	}
+}
+
+func TestTagEdit(t *testing.T) {
+	rn, w := newTestRunner(t, "echo one")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("one\n"))
+
+	// Editing the tag outside the command changes nothing.
+	w.mu.Lock()
+	w.tag = "Kill Quit Debug Get % echo one"
+	n := len(w.ctls)
+	w.mu.Unlock()
+	rn.tagEdited()
+	time.Sleep(200 * time.Millisecond)
+	w.mu.Lock()
+	if len(w.ctls) != n {
+		t.Errorf("edit outside the command sent ctl messages %q", w.ctls[n:])
+	}
+	w.mu.Unlock()
+
+	// Editing the command marks the window until the new command runs.
+	w.setCmd("echo two")
+	rn.tagEdited()
+	waitBody(t, w, equals("two\n"))
+	w.mu.Lock()
+	ctls := strings.Join(w.ctls, " ")
+	w.mu.Unlock()
+	if !strings.HasSuffix(ctls, "dirty clean") {
+		t.Errorf("ctl messages %q, want dirty then clean", ctls)
+	}
+}
+
+func TestMemLimit(t *testing.T) {
+	set(t, spillLimit, 0)
+	set(t, memLimit, 16<<10)
+	rn, w := newTestRunner(t, "seq 100000")
+	defer rn.close()
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	body := waitBody(t, w, func(body string) bool { return strings.HasSuffix(body, "100000\n") })
+	if !strings.Contains(body, "bytes omitted") {
+		t.Errorf("output not spilled with -spill 0 -mem %d", *memLimit)
+	}
+	if len(body) > 4<<10+spillTail+200 {
+		t.Errorf("window shows %d bytes", len(body))
+	}
+}
+
+func TestMoreAfterAlert(t *testing.T) {
+	set(t, spillLimit, 1000)
+	set(t, &matchRE, regexp.MustCompile("^5$"))
+	rn, w := newTestRunner(t, "seq 100000")
+	defer rn.close()
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, func(body string) bool { return strings.HasSuffix(body, "100000\n") })
+	rn.execute("More", "")
+	body := waitBody(t, w, func(body string) bool { return strings.Contains(body, "\n400\n") })
+	_, head, _ := strings.Cut(body, "\n1\n")
+	head, _, _ = strings.Cut(head, "(")
+	for i, line := range strings.Split(strings.TrimSuffix(head, "\n"), "\n") {
+		if line != strconv.Itoa(i+2) {
+			t.Fatalf("line %d of the output after More is %q", i+2, line)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	set(t, writeRate, 4)
+	rn, w := newTestRunner(t, "for i in 1 2 3 4 5 6 7 8 9 10; do seq 2000; sleep 0.1; done")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	sawNote := false
+	waitBody(t, w, func(body string) bool {
+		sawNote = sawNote || strings.Contains(body, throttleNote)
+		return strings.Count(body, "2000\n") == 10
+	})
+	time.Sleep(500 * time.Millisecond)
+	if body := w.text(); strings.Contains(body, throttleNote) {
+		t.Errorf("throttle note left in window")
+	}
+	if !sawNote {
+		t.Errorf("throttle note never shown")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.writes > 20 {
+		t.Errorf("%d writes in about a second, want at most about 4 a second", w.writes)
+	}
+}
+
+func TestStats(t *testing.T) {
+	rn, w := newTestRunner(t, "echo ok")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("ok\n"))
+	w.setCmd("exit 1")
+	rn.tagEdited()
+	waitBody(t, w, equals("(exit status 1)\n"))
+	rn.stats()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.errs) != 1 || !strings.HasPrefix(w.errs[0], "session: 2 runs, 1 failed (50%), mean ") {
+		t.Errorf("Stats printed %q", w.errs)
+	}
+}
+
+func TestHistoryRestored(t *testing.T) {
+	dir := t.TempDir()
+	defer func(wd string) { os.Chdir(wd) }(mustGetwd(t))
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	rn, w := newTestRunner(t, "echo before; exit 3")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("before\n(exit status 3)\n"))
+	rn.eng.Quit()
+
+	rn, _ = newTestRunner(t, "echo after")
+	hist := rn.history()
+	if len(hist) != 1 {
+		t.Fatalf("restored %d runs, want 1", len(hist))
+	}
+	if h := hist[0]; h.Command != "echo before; exit 3" || h.ExitCode != 3 || h.Output != "before\n" {
+		t.Errorf("restored %+v", h)
+	}
+}
+
+func TestSlowRun(t *testing.T) {
+	set(t, stateDirFlag, t.TempDir())
+	rn, w := newTestRunner(t, "sleep 0.2; echo done")
+	for i := 0; i < minSlowRuns; i++ {
+		rn.hist = append(rn.hist, record{Command: "sleep 0.2; echo done", Duration: 0.01})
+		rn.hist = append(rn.hist, record{Command: "sleep 0.2; echo done", Duration: 0.001, Stopped: "killed"})
+	}
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, func(body string) bool {
+		return strings.HasPrefix(body, "(slow run: ") && strings.HasSuffix(body, " over the last 5 runs)\ndone\n")
+	})
+}
+
+func TestTmpPerRun(t *testing.T) {
+	set(t, tmpPerRun, true)
+	rn, w := newTestRunner(t, "touch $TMPDIR/leak && echo $F_TMP")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	body := waitBody(t, w, func(body string) bool { return strings.HasSuffix(body, "\n") })
+	dir := strings.TrimSpace(body)
+	if !strings.Contains(filepath.Base(dir), "F-run") {
+		t.Fatalf("run directory is %q", dir)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not removed", dir)
+		}
+	}
+}
+
+func TestOutputsUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	defer func(wd string) { os.Chdir(wd) }(mustGetwd(t))
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	set(t, outputs, "out")
+	os.WriteFile("in", []byte("x"), 0666)
+	rn, w := newTestRunner(t, "cp in out; echo built")
+	go rn.eng.Run()
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	waitBody(t, w, equals("built\n"))
+	future := time.Now().Add(time.Hour)
+	os.Chtimes("out", future, future)
+	rn.eng.Kick(watch.Trigger{Kind: "put"})
+	waitBody(t, w, equals("up to date\n"))
+	rn.eng.Kick(watch.Trigger{Kind: "ctl"})
+	waitBody(t, w, equals("built\n"))
+}
+
+func mustGetwd(t *testing.T) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wd
+}
+
+func TestIgnoredFile(t *testing.T) {
+	for name, want := range map[string]bool{
+		"/d/main.go":        false,
+		"/d/.#main.go":      true,
+		"/d/main.go~":       true,
+		"/d/.main.go.swp":   true,
+		"/d/.DS_Store":      true,
+		"/d/4913":           true,
+		"/d/main.go.1234":   true,
+		"/d/test1234":       false,
+		"/d/2024_report.go": false,
+	} {
+		if got := ignoredFile(name); got != want {
+			t.Errorf("ignoredFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	set(t, timeout, 200*time.Millisecond)
+	for line, want := range map[string]int{
+		"true":       0,
+		"exit 3":     3,
+		"sleep 5":    exitTimeout,
+		"kill -9 $$": exitSignaled,
+	} {
+		p := &printer{done: make(chan *watch.Run, 1)}
+		eng := newEngine(p, func() (string, error) { return line, nil })
+		eng.Shell = "/bin/sh"
+		t.Cleanup(eng.Stop)
+		go eng.Run()
+		eng.Kick(watch.Trigger{Kind: "start"})
+		if got := exitStatus(<-p.done); got != want {
+			t.Errorf("%s: exit status %d, want %d", line, got, want)
+		}
+	}
+}
+
+func TestStaleBanner(t *testing.T) {
+	rn, w := newTestRunner(t, "echo old")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("old\n"))
+	w.setCmd("sleep 1; echo new")
+	rn.tagEdited()
+	waitBody(t, w, equals(banner+"old\n"))
+	waitBody(t, w, equals("new\n"))
+}
+
+func TestRunArg(t *testing.T) {
+	rn, w := newTestRunner(t, "echo {} $ARG")
+	go rn.eng.Run()
+	rn.execute("Run", "TestFoo")
+	waitBody(t, w, equals("TestFoo TestFoo\n"))
+	rn.execute("Run", "")
+	waitBody(t, w, equals("{}\n"))
+}
+
+func TestOnly(t *testing.T) {
+	rn, w := newTestRunner(t, "echo go test ./...")
+	go rn.eng.Run()
+	rn.execute("Only", "TestFoo")
+	note := "(Only TestFoo: running go test -run TestFoo; execute Only off to run all tests)\n"
+	waitBody(t, w, equals(note+"go test -run TestFoo ./...\n"))
+	rn.execute("Only off", "")
+	waitBody(t, w, equals("go test ./...\n"))
+}
+
+func TestFocusLine(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "pkg"), 0777)
+	file := filepath.Join(dir, "pkg", "x_test.go")
+	os.WriteFile(file, []byte("package pkg\nfunc TestA(t *testing.T) {}\nfunc Testify() {}\nfunc ExampleB() {}\nfunc (s) TestC() {}\n"), 0666)
+	got := focusLine("go vet ./... && go test -v ./...", file, dir)
+	if want := "go vet ./pkg && go test -run '^(TestA|ExampleB)$' -v ./pkg"; got != want {
+		t.Errorf("focusLine = %q, want %q", got, want)
+	}
+	empty := filepath.Join(dir, "pkg", "y_test.go")
+	os.WriteFile(empty, []byte("package pkg\n"), 0666)
+	if got := focusLine("go test ./...", empty, dir); got != "go test ./..." {
+		t.Errorf("focusLine with no tests = %q", got)
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	set(t, &charset, charset)
+	set(t, encodingFlag, "windows-1252")
+	if err := setEncoding(); err != nil {
+		t.Fatal(err)
+	}
+	rn, w := newTestRunner(t, `printf '\351t\351 \200\n'`)
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("été €\n"))
+}
+
+func TestShiftJIS(t *testing.T) {
+	t.Cleanup(func() { setEncoding() })
+	set(t, encodingFlag, "shift-jis")
+	if err := setEncoding(); err != nil {
+		t.Fatal(err)
+	}
+	// 日本 split across writes, then half-width katakana ｱ.
+	got := string(decode([]byte("\x93\xfa\x96"))) + string(decode([]byte("\x7b\xb1\n")))
+	if want := "日本ｱ\n"; got != want {
+		t.Errorf("decoded %q, want %q", got, want)
+	}
+}
+
+func TestSetDump(t *testing.T) {
+	w := new(fakeWin)
+	if err := setDump(w, "/src"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dumpdir /src", "dump F"}; !reflect.DeepEqual(w.ctls, want) {
+		t.Errorf("ctls %q, want %q", w.ctls, want)
+	}
+	w = &fakeWin{badCtl: "dump "}
+	if err := setDump(w, "/src"); err == nil || !strings.Contains(err.Error(), "does not support dump:") {
+		t.Errorf("setDump with no dump support: %v", err)
+	}
+}
+
+func TestAgent(t *testing.T) {
+	w := newMemWin("/src/+f")
+	w.tag = "Run Kill % echo hi"
+	rn := newRunner(w)
+	rn.eng.Shell = "/bin/sh"
+	t.Cleanup(rn.eng.Stop)
+	go rn.eng.Run()
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go w.serve(l, rn)
+
+	attach := func() (net.Conn, *json.Decoder, agentMsg) {
+		c, err := net.Dial("unix", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := json.NewDecoder(c)
+		var state agentMsg
+		if err := dec.Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+		return c, dec, state
+	}
+	c, dec, state := attach()
+	if state.Op != "state" || state.Name != "/src/+f" || state.Tag != "Run Kill % echo hi" {
+		t.Fatalf("greeting %+v", state)
+	}
+	json.NewEncoder(c).Encode(agentMsg{Op: "exec", Cmd: "Run"})
+	for {
+		var m agentMsg
+		if err := dec.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Op == "write" && m.File == "data" && m.Text == "hi\n" {
+			break
+		}
+	}
+	c.Close()
+
+	c, dec, state = attach()
+	if state.Body != "hi\n" {
+		t.Errorf("reattached to body %q, want %q", state.Body, "hi\n")
+	}
+
+	// A tag edit in one frontend shows in another.
+	c2, dec2, _ := attach()
+	json.NewEncoder(c2).Encode(agentMsg{Op: "tag", Text: "Run Kill % echo bye"})
+	var got []string
+	for len(got) < 2 {
+		var m agentMsg
+		if err := dec.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Op == "ctl" && m.Text == "cleartag" || m.Op == "write" && m.File == "tag" {
+			got = append(got, m.Op+" "+m.Text)
+		}
+	}
+	if want := []string{"ctl cleartag", "write Run Kill % echo bye"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("other frontend got %q, want %q", got, want)
+	}
+	// Echoed back unchanged, the tag goes no further.
+	json.NewEncoder(c).Encode(agentMsg{Op: "tag", Text: "Run Kill % echo bye"})
+	json.NewEncoder(c).Encode(agentMsg{Op: "exec", Cmd: "Run"})
+	for {
+		var m agentMsg
+		if err := dec2.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Op == "write" && m.File == "tag" {
+			t.Fatalf("echoed tag sent on: %+v", m)
+		}
+		if m.Op == "write" && m.File == "data" && m.Text == "bye\n" {
+			break
+		}
+	}
+}
+
+func TestIdleStopped(t *testing.T) {
+	rn, _ := newTestRunner(t, "sleep 10")
+	go rn.eng.Run()
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	rn.eng.Stop()
+	idle := make(chan bool)
+	go func() { idle <- rn.idle() }()
+	select {
+	case ok := <-idle:
+		if !ok {
+			t.Error("stopped runner not idle")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle blocked once the engine stopped")
+	}
+}
+
+func TestToggleStopped(t *testing.T) {
+	set(t, foldTests, true)
+	rn, _ := newTestRunner(t, "true")
+	go rn.eng.Run()
+	rn.eng.Stop()
+	toggled := make(chan bool)
+	go func() { toggled <- rn.toggle(0) }()
+	select {
+	case <-toggled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("toggle blocked once the engine stopped")
+	}
+}
+
+func TestRecovered(t *testing.T) {
+	rn, w := newTestRunner(t, "echo ok")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("ok\n"))
+	rn.eng.Post(nil, func() { panic("oops") })
+	waitBody(t, w, equals("ok\nF: internal error in frontend: oops (see F's standard error for the stack); still watching\n"))
+	rn.execute("Run", "")
+	waitBody(t, w, equals("ok\n"))
+}
+
+func TestAcmeEnv(t *testing.T) {
+	rn, _ := newTestRunner(t, "true")
+	rn.winid = 7
+	r := &watch.Run{Trigger: watch.Trigger{Kind: "put", File: "/src/x.go", Win: 3}}
+	rn.acmeEnv(r)
+	if want := []string{"winid=7", "F_WINID=3", "%=/src/x.go"}; !reflect.DeepEqual(r.Env, want) {
+		t.Errorf("env %q, want %q", r.Env, want)
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	dir := t.TempDir()
+	defer func(wd string) { os.Chdir(wd) }(mustGetwd(t))
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	rn, w := newTestRunner(t, "echo $F_RUN_ID $F_TRIGGER ${F_PREV_STATUS-none}; exit 3")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("1 start none\n(exit status 3)\n"))
+	rn.execute("Run", "")
+	waitBody(t, w, equals("2 run 3\n(exit status 3)\n"))
+}
+
+func TestCompare(t *testing.T) {
+	var buf bytes.Buffer
+	set(t, porcelain, true)
+	set(t, &porcelainOut, io.Writer(&buf))
+	rn, w := newTestRunner(t, "printf 'a\\nb\\nc\\n'")
+	n := len(rn.history())
+	go rn.eng.Run()
+	rn.execute("Compare", "printf 'a\\nB\\nc\\nd\\n'")
+	waitBody(t, w, equals("--- printf 'a\\nb\\nc\\n'\n+++ printf 'a\\nB\\nc\\nd\\n'\n a\n-b\n+B\n c\n+d\n"))
+	rn.execute("Compare", "printf 'a\\nb\\nc\\n'")
+	waitBody(t, w, equals("no differences (3 lines)\n"))
+	rn.execute("Compare", "")
+	waitBody(t, w, equals("a\nb\nc\n"))
+	for _, rec := range rn.history()[n:] {
+		if rec.Command != "printf 'a\\nb\\nc\\n'" {
+			t.Errorf("recorded command %q, want the tag's", rec.Command)
+		}
+	}
+	porcelainMu.Lock()
+	defer porcelainMu.Unlock()
+	if strings.Contains(buf.String(), "F-compare") {
+		t.Errorf("events show the compare script: %s", buf.String())
+	}
+}
+
+func TestFold(t *testing.T) {
+	set(t, foldTests, true)
+	rn, w := newTestRunner(t, `printf 'detail a\nok  \tpkg/a\t0.1s\n--- FAIL: TestB\nFAIL\tpkg/b\t0.2s\n'`)
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	folded := "▸ ok  \tpkg/a\t0.1s\n▾ FAIL\tpkg/b\t0.2s\n--- FAIL: TestB\n"
+	waitBody(t, w, equals(folded))
+	if !rn.toggle(0) {
+		t.Fatal("toggle(0) found no header")
+	}
+	waitBody(t, w, equals("▾ ok  \tpkg/a\t0.1s\ndetail a\n▾ FAIL\tpkg/b\t0.2s\n--- FAIL: TestB\n"))
+	if !rn.toggle(len([]rune("▾ ok  \tpkg/a\t0.1s\ndetail a\n")) + 3) {
+		t.Fatal("toggle found no second header")
+	}
+	waitBody(t, w, equals("▾ ok  \tpkg/a\t0.1s\ndetail a\n▸ FAIL\tpkg/b\t0.2s\n"))
+	if rn.toggle(len([]rune("▾ ok  \tpkg/a\t0.1s\n")) + 1) {
+		t.Error("toggle on a detail line")
+	}
+}
+
+func TestFoldAfterAlert(t *testing.T) {
+	set(t, foldTests, true)
+	set(t, &matchRE, regexp.MustCompile("TestB"))
+	rn, w := newTestRunner(t, `printf 'detail a\nok  \tpkg/a\t0.1s\n--- FAIL: TestB\nFAIL\tpkg/b\t0.2s\n'`)
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	body := waitBody(t, w, func(body string) bool {
+		return strings.HasSuffix(body, "▸ ok  \tpkg/a\t0.1s\n▾ FAIL\tpkg/b\t0.2s\n--- FAIL: TestB\n")
+	})
+	alert, _, _ := strings.Cut(body, "▸")
+	if alert == "" {
+		t.Fatalf("no alert above the output: %q", body)
+	}
+	if !rn.toggle(len([]rune(alert))) {
+		t.Fatal("toggle found no header below the alert")
+	}
+	waitBody(t, w, equals(alert+"▾ ok  \tpkg/a\t0.1s\ndetail a\n▾ FAIL\tpkg/b\t0.2s\n--- FAIL: TestB\n"))
+}
+
+func TestWarm(t *testing.T) {
+	set(t, warmCmd, "sleep 0.2; echo warming; exit 2")
+	rn, w := newTestRunner(t, "echo run")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("run\n"))
+	// An edit to the command while warming leaves the window dirty.
+	rn.mu.Lock()
+	rn.cmd = "echo edited"
+	rn.mark()
+	rn.mu.Unlock()
+	waitBody(t, w, equals("run\n% sleep 0.2; echo warming; exit 2\nwarming\n(exit status 2)\n"))
+	w.mu.Lock()
+	if last := w.ctls[len(w.ctls)-1]; last != "dirty" {
+		t.Errorf("after warm failure, last ctl %q, want dirty", last)
+	}
+	w.mu.Unlock()
+	rn.close()
+}
+
+func TestDoctorStateDir(t *testing.T) {
+	set(t, stateDirFlag, filepath.Join(t.TempDir(), "state"))
+	if result, fix, ok := checkStateDir(); !ok {
+		t.Fatalf("checkStateDir = %q, %q, false", result, fix)
+	}
+	f := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(f, nil, 0666)
+	*stateDirFlag = filepath.Join(f, "state")
+	if _, fix, ok := checkStateDir(); ok || fix == "" {
+		t.Fatalf("checkStateDir under a file: ok=%v fix=%q", ok, fix)
+	}
+}
+
+func TestList(t *testing.T) {
+	set(t, stateDirFlag, t.TempDir())
+	stale := filepath.Join(instancesDir(), "999999999.json")
+	rn, w := newTestRunner(t, "echo hi; exit 3")
+	rn.register("")
+	os.WriteFile(stale, []byte(`{"pid":999999999,"dir":"/gone"}`), 0666)
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("hi\n(exit status 3)\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list, err := listInstances()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 1 && list[0].Status == "exit 3" && !list[0].Running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("instances = %+v", list)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(stale); err == nil {
+		t.Errorf("stale instance file not removed")
+	}
+	var b strings.Builder
+	runList(&b)
+	if out := b.String(); !strings.Contains(out, "exit 3") || !strings.Contains(out, "echo hi; exit 3") {
+		t.Errorf("runList:\n%s", out)
+	}
+	rn.close()
+	if list, _ := listInstances(); len(list) != 0 {
+		t.Errorf("after close, instances = %+v", list)
+	}
+}
+
+func TestFindInstance(t *testing.T) {
+	list := []instance{
+		{PID: 1, Dir: "/a", Agent: true},
+		{PID: 2, Dir: "/b", Agent: true},
+		{PID: 3, Dir: "/a", Win: 7},
+		{PID: 4, Dir: "/a", Agent: true},
+	}
+	if inst := findInstance(list, "/a"); inst == nil || inst.PID != 3 {
+		t.Errorf("findInstance(/a) = %+v, want PID 3", inst)
+	}
+	if inst := findInstance(list, "/b"); inst == nil || inst.PID != 2 {
+		t.Errorf("findInstance(/b) = %+v, want PID 2", inst)
+	}
+	if inst := findInstance(list, "/c"); inst != nil {
+		t.Errorf("findInstance(/c) = %+v, want nil", inst)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	got := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&m)
+		got <- m
+	}))
+	defer srv.Close()
+	set(t, webhook, srv.URL)
+	rn, w := newTestRunner(t, "echo hook; exit 2")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("hook\n(exit status 2)\n"))
+	select {
+	case m := <-got:
+		if m["command"] != "echo hook; exit 2" || m["exit_code"] != 2.0 || m["output"] != "hook\n" || m["dir"] != mustGetwd(t) {
+			t.Errorf("webhook got %v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestSteps(t *testing.T) {
+	var buf bytes.Buffer
+	set(t, porcelain, true)
+	set(t, &porcelainOut, io.Writer(&buf))
+	set(t, stepOrder, "fast")
+	set(t, failFast, true)
+	rn, w := newTestRunner(t, "echo slow\n% echo a; exit 4\n% echo quick")
+	rn.hist = []record{{Steps: []stepTime{{"echo slow", 5, 0}, {"echo quick", 1, 0}, {"echo a; exit 4", 2, 4}}}}
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("ran quickest first:\n\techo quick\n\techo a; exit 4\n\techo slow\n"+
+		"echo a; exit 4 failed (exit 4); skipped 1 more:\n\techo slow\n\nquick\na\n(exit status 4)\n"))
+	rec := rn.history()[1]
+	if rec.Command != "echo slow\necho a; exit 4\necho quick" || len(rec.Steps) != 2 || rec.Steps[1].ExitCode != 4 {
+		t.Errorf("record = %+v", rec)
+	}
+	rn.stats()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.errs) != 1 {
+		t.Fatalf("Stats printed %q", w.errs)
+	}
+	if session, _, _ := strings.Cut(w.errs[0], "\nhistory: "); !strings.HasSuffix(session, "(echo slow\necho a; exit 4\necho quick at "+rec.Start.Format("Jan 2 15:04:05")+")") {
+		t.Errorf("Stats printed %q, want the tag's command as the slowest", session)
+	}
+	porcelainMu.Lock()
+	defer porcelainMu.Unlock()
+	if strings.Contains(buf.String(), "F-step") {
+		t.Errorf("events show the step script: %s", buf.String())
+	}
+}
+
+func TestStepFilter(t *testing.T) {
+	p := &stepPlan{steps: []string{"a", "b"}, marker: "F-step-1-2"}
+	var out []byte
+	for _, c := range []byte("x\nyF-step-1-2 0 0\nF-stepz\nF-step-1-2 1 3\nF-st") {
+		out = append(out, p.filter([]byte{c})...)
+	}
+	out = append(out, p.flush()...)
+	if string(out) != "x\nyF-stepz\nF-st" {
+		t.Errorf("filtered output %q", out)
+	}
+	if len(p.times) != 2 || p.times[0].Command != "a" || p.times[1].ExitCode != 3 {
+		t.Errorf("times = %+v", p.times)
+	}
+}
+
+func TestControl(t *testing.T) {
+	set(t, stateDirFlag, t.TempDir())
+	rn, w := newTestRunner(t, "echo one\n% echo later\nnotes")
+	rn.register("")
+	rn.listenControl()
+	defer rn.close()
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("one\nlater\n"))
+
+	ctl := func(args ...string) (string, int) {
+		var b strings.Builder
+		status := runCtl(&b, args)
+		return b.String(), status
+	}
+	if out, status := ctl("set", "cmd", "echo two"); out != "ok\n" || status != 0 {
+		t.Fatalf("set cmd: %q, %d", out, status)
+	}
+	waitBody(t, w, equals("two\nlater\n"))
+	if tag, _ := w.ReadAll("tag"); string(tag) != "Kill Quit Debug % echo two\n% echo later\nnotes" {
+		t.Errorf("tag = %q", tag)
+	}
+	out, status := ctl("status")
+	if status != 0 || !strings.Contains(out, "cmd echo two; echo later\n") || !strings.Contains(out, "last ok\n") {
+		t.Errorf("status: %d\n%s", status, out)
+	}
+	if out, status := ctl("frob"); status != 1 || !strings.HasPrefix(out, "error: unknown request") {
+		t.Errorf("frob: %q, %d", out, status)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	set, err := parseConfig("# settings\n-match=WARN|TODO\n\n  dot top\n-fold\n-hang 2s\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"match": "WARN|TODO", "dot": "top", "fold": "true", "hang": "2s"}
+	if !reflect.DeepEqual(set, want) {
+		t.Errorf("parseConfig = %v, want %v", set, want)
+	}
+	for _, text := range []string{"-nosuchflag", "-match"} {
+		if _, err := parseConfig(text); err == nil {
+			t.Errorf("parseConfig(%q) succeeded", text)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	t.Cleanup(func() {
+		compileMatch()
+		for n := range configSet {
+			delete(configSet, n)
+		}
+	})
+	set(t, matchFlag, *matchFlag)
+	set(t, dotPlace, *dotPlace)
+	set(t, toolWindows, *toolWindows)
+	set(t, shellFlag, *shellFlag)
+	sh := filepath.Join(t.TempDir(), "sh")
+	if err := os.WriteFile(sh, []byte("#!/bin/sh\necho via sh\nexec /bin/sh \"$@\"\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	rn, w := newTestRunner(t, "echo hi")
+	go rn.eng.Run()
+	reload := func(set map[string]string) {
+		done := make(chan bool)
+		rn.eng.Post(nil, func() { rn.reloadConfig("flags", set); close(done) })
+		<-done
+	}
+	reload(map[string]string{"match": "WARN", "fs": "true", "tmp": "true"})
+	waitBody(t, w, equals("F: flags: reloaded -match=WARN; restart F to change -fs -tmp\n"))
+	if matchRE == nil || matchRE.String() != "WARN" || *fsWatch || *tmpPerRun {
+		t.Errorf("after reload, matchRE = %v, -fs = %v, -tmp = %v", matchRE, *fsWatch, *tmpPerRun)
+	}
+	reload(map[string]string{"dot": "sideways"})
+	waitBody(t, w, func(body string) bool { return strings.Contains(body, "not reloaded") })
+	if *dotPlace != "keep" || *matchFlag != "WARN" {
+		t.Errorf("after bad reload, -dot = %q, -match = %q", *dotPlace, *matchFlag)
+	}
+	reload(map[string]string{"match": "WARN", "toolwins": "true", "shell": sh})
+	waitBody(t, w, func(body string) bool {
+		return strings.HasSuffix(body, "F: flags: reloaded -shell="+sh+" -toolwins=true\n")
+	})
+	if why := putIgnored("/src/+Errors", "/src", "/src/"); why != "" {
+		t.Errorf("with -toolwins reloaded, Put of +Errors %s", why)
+	}
+	rn.execute("Run", "")
+	waitBody(t, w, func(body string) bool { return strings.HasSuffix(body, "\nvia sh\nhi\n") })
+	reload(map[string]string{})
+	waitBody(t, w, func(body string) bool {
+		return strings.HasSuffix(body, "F: flags: reloaded -match= -shell= -toolwins=false\n")
+	})
+	if matchRE != nil {
+		t.Errorf("after removing -match, matchRE = %v", matchRE)
+	}
+}
+
+func TestRestartArgs(t *testing.T) {
+	set(t, cmdFile, ".f")
+	argv := restartArgs("make")
+	if last := argv[len(argv)-1]; last != "-cmdfile=.f" {
+		t.Errorf("restartArgs with -cmdfile ends in %q, want -cmdfile=.f", last)
+	}
+	*cmdFile = ""
+	line := "echo a \\\n\tb\necho c"
+	argv = restartArgs(line)
+	if got := parseCmd("% " + argv[len(argv)-1]); got != line {
+		t.Errorf("restarted with command %q, want %q", got, line)
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	index := "" +
+		"          1          32          10           0           1 /src/x.go Del Snarf | Look \n" +
+		"          2          30           0           0           0 /src/y.go Del Snarf | Look \n" +
+		"          3          29           0           1           1 /src/ Del Snarf Get | Look \n" +
+		"          4           0           0           0           1\n"
+	want := map[string]bool{"/src/x.go": true, "/src/": true}
+	if got := parseIndex(index); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseIndex = %v, want %v", got, want)
+	}
+}
+
+func TestLastRecord(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "events.json")
+	if _, ok := lastRecord(name); ok {
+		t.Error("lastRecord of a missing file reported a record")
+	}
+	big := strings.Repeat("x", 40<<10)
+	data := `{"command":"first","exit_code":0,"output":"` + big + `"}` + "\n" +
+		`{"command":"second","exit_code":2,"output":"` + big + `"}` + "\n" +
+		`{"command":"third","exit_code":1` + "\n" // cut off mid-write
+	if err := os.WriteFile(name, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := lastRecord(name)
+	if !ok || rec.Command != "second" || rec.ExitCode != 2 {
+		t.Errorf("lastRecord = %q, %d, %v; want second, 2, true", rec.Command, rec.ExitCode, ok)
+	}
+}
+
+func TestStopProjects(t *testing.T) {
+	set(t, &projectGrace, 500*time.Millisecond)
+	var projects []*project
+	for _, script := range []string{"trap 'exit 0' INT; while :; do sleep 0.05; done", "trap '' INT; sleep 10"} {
+		p := &project{cmd: exec.Command("/bin/sh", "-c", script), exited: make(chan bool)}
+		watch.Isolate(p.cmd)
+		if err := p.cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			p.cmd.Wait()
+			close(p.exited)
+		}()
+		projects = append(projects, p)
+	}
+	time.Sleep(100 * time.Millisecond) // let the traps be set
+	start := time.Now()
+	stopProjects(projects)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("stopProjects took %v", d)
+	}
+	if !projects[0].cmd.ProcessState.Success() {
+		t.Errorf("interrupted project exited with %v, want it to clean up and exit 0", projects[0].cmd.ProcessState)
+	}
+	if projects[1].cmd.ProcessState.Success() {
+		t.Errorf("project ignoring interrupts exited with %v, want it killed", projects[1].cmd.ProcessState)
+	}
+}
+
+// A logBuffer collects what is logged while a test runs.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog sends the log to a logBuffer for the rest of t.
+func captureLog(t *testing.T) *logBuffer {
+	b := new(logBuffer)
+	log.SetOutput(b)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return b
+}
+
+func TestVerbose(t *testing.T) {
+	logged := captureLog(t)
+	set(t, verbose, true)
+	rn, w := newTestRunner(t, "echo hi")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("hi\n"))
+	w.mu.Lock()
+	w.tag = "Look " + w.tag
+	w.mu.Unlock()
+	if err := rn.tagEdited(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"trigger start : run queued", "tag edit outside the command: ignored"} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("log %q does not contain %q", logged.String(), want)
+		}
+	}
+}
+
+func TestPutIgnored(t *testing.T) {
+	set(t, toolWindows, false)
+	tests := []struct {
+		name     string
+		toolwins bool
+		want     bool
+	}{
+		{"/src/x.go", false, false},
+		{"/src/+Errors", false, true},
+		{"/src/+f", false, true},
+		{"/src/+watch", false, true},
+		{"/src/+Errors", true, false},
+		{"/src/sub/x.go", false, true},
+		{"/other/x.go", false, true},
+	}
+	for _, tt := range tests {
+		*toolWindows = tt.toolwins
+		if why := putIgnored(tt.name, "/src", "/src/"); (why != "") != tt.want {
+			t.Errorf("putIgnored(%q) with -toolwins=%v = %q, want ignored %v", tt.name, tt.toolwins, why, tt.want)
+		}
+	}
+}
+
+func TestCmdFileReload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), ".f")
+	if err := os.WriteFile(name, []byte("echo one\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	set(t, cmdFile, name)
+	rn, w := newTestRunner(t, "")
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("one\n"))
+	go watchCmdFile(name, rn.eng.Kick)
+	time.Sleep(100 * time.Millisecond) // let it see the file as it is
+	if err := os.WriteFile(name, []byte("echo two\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	os.Chtimes(name, later, later)
+	waitBody(t, w, equals("two\n"))
+}
+
+func TestLowPower(t *testing.T) {
+	t.Cleanup(func() { onBattery.Store(false) })
+	set(t, lowPowerFlag, true)
+	set(t, delay, 10*time.Millisecond)
+	rn, w := newTestRunner(t, "echo hi")
+	watchPower(rn.eng)
+	if !lowPower() {
+		t.Fatal("-lowpower did not enter low-power mode")
+	}
+	start := time.Now()
+	rn.eng.Kick(watch.Trigger{Kind: "start"})
+	go rn.eng.Run()
+	waitBody(t, w, equals("hi\n"))
+	if d := time.Since(start); d < lowPowerDelay {
+		t.Errorf("run started after %v in low-power mode, want at least %v", d, lowPowerDelay)
+	}
+}
+
+func TestLogDelay(t *testing.T) {
+	var waits []time.Duration
+	d := minLogDelay / 2
+	for _, n := range []int{0, 0, 0, 5, 0} {
+		d = logDelay(d, n)
+		waits = append(waits, d)
+	}
+	ms := time.Millisecond
+	if want := []time.Duration{100 * ms, 200 * ms, 400 * ms, 100 * ms, 200 * ms}; !reflect.DeepEqual(waits, want) {
+		t.Errorf("waits %v, want %v", waits, want)
+	}
+	for i := 0; i < 20; i++ {
+		d = logDelay(d, 0)
+	}
+	if d != maxLogDelay {
+		t.Errorf("after many failures, wait %v, want %v", d, maxLogDelay)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var slowFactor = flag.Float64("slow", 3, "flag runs taking more than `n` times the median duration of earlier runs of the command (0 for never)")
+
+// A run is compared with at most slowRuns earlier runs of its command,
+// and only once there are at least minSlowRuns of them.
+const (
+	slowRuns    = 20
+	minSlowRuns = 5
+)
+
+// slowNote returns a banner for a run of line that took d, if d is
+// more than -slow times the median duration of the latest runs of line
+// in hist, and otherwise "". Runs that were stopped short, by being
+// killed, superseded, or timed out, say nothing of how long the command
+// takes, and are left out.
+func slowNote(hist []record, line string, d time.Duration) string {
+	if *slowFactor <= 0 {
+		return ""
+	}
+	var durs []time.Duration
+	for i := len(hist) - 1; i >= 0 && len(durs) < slowRuns; i-- {
+		if hist[i].Command == line && hist[i].Stopped == "" {
+			durs = append(durs, hist[i].stat().dur)
+		}
+	}
+	if len(durs) < minSlowRuns {
+		return ""
+	}
+	sort.Slice(durs, func(i, j int) bool { return durs[i] < durs[j] })
+	med := durs[len(durs)/2]
+	if len(durs)%2 == 0 {
+		med = (durs[len(durs)/2-1] + durs[len(durs)/2]) / 2
+	}
+	if med <= 0 || float64(d) <= *slowFactor*float64(med) {
+		return ""
+	}
+	return fmt.Sprintf("(slow run: %v, %.1f× the median of %v over the last %d runs)\n",
+		d.Round(time.Millisecond), float64(d)/float64(med), med.Round(time.Millisecond), len(durs))
+}
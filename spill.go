@@ -0,0 +1,174 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+var spillLimit = flag.Int64("spill", 256<<10, "show at most `n` bytes of a run's output, keeping the rest in a file paged with More (0 for no limit)")
+
+// spillTail is how much of the end of a spilled output is shown.
+const spillTail = 64 << 10
+
+// A spool holds a copy of a run's output in a temporary file,
+// so that output larger than -spill (see showLimit) need not all go
+// into the window. The window then shows the first -spill bytes
+// (the head), a marker line, and the last spillTail bytes (the tail).
+// Executing More moves the next -spill bytes from the file into the
+// window, just above the marker.
+//
+// A spool is only used from the engine's frontend goroutine.
+type spool struct {
+	f       *os.File
+	limit   int64 // size of the head, and of each page shown by More
+	n       int64 // bytes written to f
+	shown   int64 // bytes of f shown in the head
+	spilled bool  // output has exceeded the limit
+	done    bool  // run has finished
+	eol     bool  // output so far ends with a newline
+
+	tail   int64 // offset in f of the tail
+	marker int   // rune offset of the marker line in the output
+	mlen   int   // rune length of the marker line
+}
+
+// newSpool returns a spool for a new run, or nil if there is no limit.
+func newSpool() *spool {
+	limit := showLimit()
+	if limit <= 0 {
+		return nil
+	}
+	f, err := os.CreateTemp("", "F-output-")
+	if err != nil {
+		return nil
+	}
+	return &spool{f: f, limit: limit}
+}
+
+// add records p and returns the part of it to show in the window.
+func (s *spool) add(p []byte) []byte {
+	if s == nil {
+		return p
+	}
+	s.f.Write(p)
+	s.n += int64(len(p))
+	s.eol = len(p) > 0 && p[len(p)-1] == '\n'
+	if s.spilled {
+		return nil
+	}
+	if s.shown+int64(len(p)) <= s.limit {
+		s.shown += int64(len(p))
+		return p
+	}
+	// Stop at the last line that fits.
+	s.spilled = true
+	keep := p[:s.limit-s.shown]
+	if i := bytes.LastIndexByte(keep, '\n'); i >= 0 {
+		keep = keep[:i+1]
+	} else {
+		keep = nil
+	}
+	s.shown += int64(len(keep))
+	return keep
+}
+
+// finish ends a spilled output by writing the marker line and the tail to out.
+// bol reports whether the output shown so far ends with a newline
+// (or is empty), and finish returns the same for the output after it
+// has written the tail.
+func (s *spool) finish(out *view, bol bool) bool {
+	if s == nil || !s.spilled {
+		return bol
+	}
+	s.done = true
+	if !bol {
+		out.printf("\\\n")
+	}
+	s.tail = s.n - spillTail
+	if s.tail < s.shown {
+		s.tail = s.shown
+	}
+	tail := make([]byte, s.n-s.tail)
+	s.f.ReadAt(tail, s.tail)
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 && s.tail > s.shown {
+		// start the tail on a line boundary
+		s.tail += int64(i + 1)
+		tail = tail[i+1:]
+	}
+	if s.tail == s.shown {
+		// Nothing omitted after all.
+		s.shown = s.n
+		out.write(tail)
+		return len(tail) == 0 || s.eol
+	}
+	s.marker = out.offset()
+	m := s.markerLine()
+	s.mlen = utf8.RuneCountInString(m)
+	out.printf("%s", m)
+	out.write(tail)
+	return len(tail) == 0 || s.eol
+}
+
+func (s *spool) markerLine() string {
+	return fmt.Sprintf("(%d bytes omitted; full output in %s; execute More to see them)\n", s.tail-s.shown, s.f.Name())
+}
+
+// more shows the next page of omitted output in w,
+// whose output starts at rune offset off in the body.
+func (s *spool) more(w window, off int) {
+	if s == nil || !s.spilled {
+		w.Errf("no omitted output")
+		return
+	}
+	if !s.done {
+		w.Errf("command still running")
+		return
+	}
+	if s.shown >= s.tail {
+		return
+	}
+	n := s.tail - s.shown
+	if n > s.limit {
+		n = s.limit
+	}
+	page := make([]byte, n)
+	if _, err := s.f.ReadAt(page, s.shown); err != nil && err != io.EOF {
+		w.Errf("More: %v", err)
+		return
+	}
+	if s.shown+n < s.tail {
+		if i := bytes.LastIndexByte(page, '\n'); i >= 0 {
+			page = page[:i+1]
+		}
+	}
+	w.Addr("#%d", off+s.marker)
+	w.Write("data", page)
+	s.shown += int64(len(page))
+	s.marker += utf8.RuneCount(page)
+
+	// Update or remove the marker.
+	m := ""
+	if s.shown < s.tail {
+		m = s.markerLine()
+	}
+	w.Addr("#%d,#%d", off+s.marker, off+s.marker+s.mlen)
+	w.Write("data", []byte(m))
+	s.mlen = utf8.RuneCountInString(m)
+}
+
+// remove deletes the spool's file.
+func (s *spool) remove() {
+	if s == nil {
+		return
+	}
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
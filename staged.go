@@ -0,0 +1,94 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+var stagedMode = flag.Bool("staged", false, "run when the git index changes, passing the staged files as {staged} and $F_STAGED")
+
+// watchStaged calls kick each time the git index changes.
+// The index is replaced by rename on every update,
+// so it is simplest to poll its modification time.
+func watchStaged(kick func(watch.Trigger)) error {
+	out, err := exec.Command("git", "rev-parse", "--git-path", "index").Output()
+	if err != nil {
+		return fmt.Errorf("find git index: %w", err)
+	}
+	index, err := filepath.Abs(strings.TrimSpace(string(out)))
+	if err != nil {
+		return err
+	}
+	go func() {
+		var last time.Time
+		if info, err := os.Stat(index); err == nil {
+			last = info.ModTime()
+		}
+		for {
+			time.Sleep(500 * time.Millisecond)
+			info, err := os.Stat(index)
+			if err != nil || info.ModTime().Equal(last) {
+				continue
+			}
+			last = info.ModTime()
+			kick(watch.Trigger{Kind: "staged", File: index})
+		}
+	}()
+	return nil
+}
+
+// stagedFiles returns the names of the files added, copied,
+// modified, or renamed in the git index.
+func stagedFiles() ([]string, error) {
+	out, err := exec.Command("git", "diff", "--cached", "--name-only", "--diff-filter=ACMR", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("list staged files: %w", err)
+	}
+	return strings.FieldsFunc(string(out), func(r rune) bool { return r == 0 }), nil
+}
+
+// prepareStaged passes the staged files to run r.
+func prepareStaged(r *watch.Run) error {
+	line, setting, err := expandStaged(r.Line)
+	if err != nil {
+		return err
+	}
+	r.Line = line
+	r.Env = append(r.Env, setting)
+	return nil
+}
+
+// expandStaged returns line with {staged} replaced by the quoted staged
+// file names, and the environment setting for $F_STAGED, which holds
+// the names one per line.
+func expandStaged(line string) (string, string, error) {
+	files, err := stagedFiles()
+	if err != nil {
+		return line, "", err
+	}
+	quoted := make([]string, len(files))
+	for i, f := range files {
+		quoted[i] = rcQuote(f)
+	}
+	line = strings.ReplaceAll(line, "{staged}", strings.Join(quoted, " "))
+	return line, "F_STAGED=" + strings.Join(files, "\n"), nil
+}
+
+// rcQuote quotes s, if necessary, as a single rc word.
+func rcQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n#;&|^$=`'{}()<>[]*?\\\"") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,118 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var stateDirFlag = flag.String("state", "", "keep F's state, such as run history, in `dir` (default $XDG_STATE_HOME/F or ~/.local/state/F; \"none\" to keep none)")
+
+// maxHistory is the number of runs kept in a directory's history.
+const maxHistory = 100
+
+var historyMu sync.Mutex
+
+// stateDir returns the directory for F's state, or "" for none.
+func stateDir() string {
+	switch dir := *stateDirFlag; dir {
+	case "none":
+		return ""
+	case "":
+	default:
+		return dir
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "F")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "F")
+}
+
+// historyFile returns the name of the file holding the history of
+// runs in the current directory, or "" if there is none.
+func historyFile() string {
+	dir := stateDir()
+	if dir == "" {
+		return ""
+	}
+	pwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(pwd))
+	return filepath.Join(dir, fmt.Sprintf("history-%x.json", sum[:8]))
+}
+
+// loadHistory returns the runs recorded in the current directory's
+// history, oldest first.
+func loadHistory() ([]record, error) {
+	name := historyFile()
+	if name == "" {
+		return nil, nil
+	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recs []record
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var rec record
+		if json.Unmarshal(s.Bytes(), &rec) == nil {
+			recs = append(recs, rec)
+		}
+	}
+	return recs, s.Err()
+}
+
+// saveHistory adds rec to the current directory's history,
+// keeping the last maxHistory runs.
+func saveHistory(rec *record) error {
+	name := historyFile()
+	if name == "" {
+		return nil
+	}
+	js, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	b, _ := os.ReadFile(name)
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) >= maxHistory {
+		lines = lines[len(lines)-maxHistory+1:]
+	}
+	lines = append(lines, append(js, '\n'))
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, bytes.Join(lines, nil), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,111 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A runStat is what Stats knows about a completed run.
+type runStat struct {
+	start  time.Time
+	dur    time.Duration
+	failed bool
+	line   string
+}
+
+// stats prints statistics about the runs of this session, and about
+// the history of runs here: every run recorded in the -events file,
+// if there is one, or else the recent runs, from this session and
+// earlier ones, kept in the state directory.
+func (rn *Runner) stats() {
+	rn.mu.Lock()
+	runs := append([]runStat(nil), rn.runs...)
+	rn.mu.Unlock()
+	var hist []runStat
+	for _, rec := range rn.history() {
+		hist = append(hist, rec.stat())
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "session: %s\n", summarize(runs))
+	if *eventsFile != "" {
+		all, err := readHistory(*eventsFile)
+		if err != nil {
+			fmt.Fprintf(&b, "history: %v\n", err)
+		} else {
+			fmt.Fprintf(&b, "history: %s\n", summarize(all))
+		}
+	} else if len(hist) > 0 {
+		fmt.Fprintf(&b, "history: %s\n", summarize(hist))
+	}
+	rn.win.Errf("%s", strings.TrimSuffix(b.String(), "\n"))
+}
+
+// summarize returns a one-line summary of runs.
+func summarize(runs []runStat) string {
+	if len(runs) == 0 {
+		return "no runs"
+	}
+	failed := 0
+	var total time.Duration
+	durs := make([]time.Duration, len(runs))
+	slowest := runs[0]
+	for i, r := range runs {
+		if r.failed {
+			failed++
+		}
+		total += r.dur
+		durs[i] = r.dur
+		if r.dur > slowest.dur {
+			slowest = r
+		}
+	}
+	sort.Slice(durs, func(i, j int) bool { return durs[i] < durs[j] })
+	p95 := durs[(len(durs)*95+99)/100-1]
+	return fmt.Sprintf("%d runs, %d failed (%.0f%%), mean %v, p95 %v, slowest %v (%s at %s)",
+		len(runs), failed, 100*float64(failed)/float64(len(runs)),
+		round(total/time.Duration(len(runs))), round(p95),
+		round(slowest.dur), slowest.line, slowest.start.Format("Jan 2 15:04:05"))
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
+
+// readHistory returns the runs recorded in the -events file name.
+func readHistory(name string) ([]runStat, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var runs []runStat
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var rec record
+		if json.Unmarshal(s.Bytes(), &rec) != nil {
+			continue
+		}
+		runs = append(runs, rec.stat())
+	}
+	return runs, s.Err()
+}
+
+// stat returns the runStat for the run described by rec.
+func (rec *record) stat() runStat {
+	return runStat{
+		start:  rec.Start,
+		dur:    time.Duration(rec.Duration * float64(time.Second)),
+		failed: rec.ExitCode != 0 || rec.Error != "",
+		line:   rec.Command,
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hherman1/F/watch"
+)
+
+var stepOrder = flag.String("order", "tag", "run the commands of a tag with several in `order`: tag (as written) or fast (quickest first, as learned from the history)")
+var failFast = flag.Bool("failfast", false, "when the tag has several commands, stop at the first that fails")
+
+// checkOrder reports whether -order is valid.
+func checkOrder() error {
+	switch *stepOrder {
+	case "tag", "fast":
+		return nil
+	}
+	return fmt.Errorf("bad -order %q: want tag or fast", *stepOrder)
+}
+
+// A stepTime records how one command of a run with several went.
+type stepTime struct {
+	Command  string  `json:"command"`
+	Duration float64 `json:"duration"` // seconds
+	ExitCode int     `json:"exit_code"`
+}
+
+// A stepPlan is a run of the several commands in the tag, in the order
+// -order asks for, with a marker line printed after each, carrying its
+// exit status. The markers are taken out of the output as it arrives,
+// timing each command, and, with -failfast, the script exits after the
+// first command that fails.
+type stepPlan struct {
+	line   string   // command line before it was rewritten
+	steps  []string // commands, in the order run
+	marker string
+
+	// Used only from the engine's frontend goroutine.
+	last  time.Time // when the latest step began
+	times []stepTime
+	carry []byte // output that may be the start of a marker
+	done  bool
+}
+
+// splitSteps returns the commands in the command line line, as parseCmd
+// joined them.
+func splitSteps(line string) []string {
+	var steps []string
+	for line != "" {
+		var cmd string
+		cmd, line = cutCmd(line)
+		if cmd != "" {
+			steps = append(steps, cmd)
+		}
+	}
+	return steps
+}
+
+// prepareSteps rewrites the command of run r, if it has several
+// commands and -order or -failfast apply, to run them as a stepPlan.
+func (rn *Runner) prepareSteps(r *watch.Run) {
+	if *stepOrder == "tag" && !*failFast {
+		return
+	}
+	steps := splitSteps(r.Line)
+	if len(steps) < 2 {
+		return
+	}
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if rn.comparing[r.ID] != nil {
+		return
+	}
+	if *stepOrder == "fast" {
+		d := stepDurations(rn.hist)
+		sort.SliceStable(steps, func(i, j int) bool { return d[steps[i]] < d[steps[j]] })
+	}
+	p := &stepPlan{line: r.Line, steps: steps, marker: fmt.Sprintf("F-step-%d-%d", r.ID, time.Now().UnixNano())}
+	r.Line = p.script(filepath.Base(r.Shell) == "rc")
+	rn.stepping[r.ID] = p
+}
+
+// stepDurations returns the latest duration, in seconds, of each command
+// run as a step in hist. Commands never run are left out, so they count
+// as quickest and are timed on their first run.
+func stepDurations(hist []record) map[string]float64 {
+	d := make(map[string]float64)
+	for _, rec := range hist {
+		for _, s := range rec.Steps {
+			d[s.Command] = s.Duration
+		}
+	}
+	return d
+}
+
+// script returns the shell script running the plan's steps,
+// in rc's syntax if rc is set and in sh's otherwise.
+func (p *stepPlan) script(rc bool) string {
+	var b strings.Builder
+	for i, s := range p.steps {
+		last := i == len(p.steps)-1
+		b.WriteString(s + "\n")
+		if rc {
+			fmt.Fprintf(&b, "fstatus=$status; echo %s %d $fstatus\n", p.marker, i)
+			if last {
+				b.WriteString("exit $fstatus\n")
+			} else if *failFast {
+				b.WriteString("if(! ~ $fstatus '') exit $fstatus\n")
+			}
+		} else {
+			fmt.Fprintf(&b, "fstatus=$?; echo %s %d $fstatus\n", p.marker, i)
+			if last {
+				b.WriteString("exit $fstatus\n")
+			} else if *failFast {
+				b.WriteString("[ $fstatus = 0 ] || exit $fstatus\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// filter returns the output p with the plan's markers taken out,
+// noting the end of each step. Output that may be the start of a
+// marker is held until the rest of it arrives, or flush.
+func (p *stepPlan) filter(b []byte) []byte {
+	if p.done {
+		return b
+	}
+	data := append(p.carry, b...)
+	p.carry = nil
+	var out []byte
+	for {
+		i := bytes.Index(data, []byte(p.marker))
+		if i < 0 {
+			k := len(p.marker) - 1
+			if k > len(data) {
+				k = len(data)
+			}
+			for ; k > 0 && !strings.HasPrefix(p.marker, string(data[len(data)-k:])); k-- {
+			}
+			p.carry = append(p.carry, data[len(data)-k:]...)
+			return append(out, data[:len(data)-k]...)
+		}
+		j := bytes.IndexByte(data[i:], '\n')
+		if j < 0 {
+			p.carry = append(p.carry, data[i:]...)
+			return append(out, data[:i]...)
+		}
+		out = append(out, data[:i]...)
+		p.stepDone(string(data[i+len(p.marker) : i+j]))
+		data = data[i+j+1:]
+	}
+}
+
+// flush returns the output held by filter, which passes on
+// all later output as it is.
+func (p *stepPlan) flush() []byte {
+	p.done = true
+	b := p.carry
+	p.carry = nil
+	return b
+}
+
+// stepDone notes the end of a step, described by the rest of its
+// marker line: the index of the step and its exit status.
+func (p *stepPlan) stepDone(rest string) {
+	f := strings.Fields(rest)
+	if len(f) == 0 {
+		return
+	}
+	i, err := strconv.Atoi(f[0])
+	if err != nil || i < 0 || i >= len(p.steps) {
+		return
+	}
+	code := 0
+	if len(f) > 1 && f[1] != "0" {
+		if code, err = strconv.Atoi(f[1]); err != nil || code == 0 {
+			code = 1 // rc's status need not be a number
+		}
+	}
+	now := time.Now()
+	p.times = append(p.times, stepTime{p.steps[i], now.Sub(p.last).Seconds(), code})
+	p.last = now
+}
+
+// end notes the end of run r: the end, too, of the step that was
+// running, if r exited before its marker.
+func (p *stepPlan) end(r *watch.Run) {
+	if n := len(p.times); n < len(p.steps) && r.Started && !r.Superseded {
+		p.times = append(p.times, stepTime{p.steps[n], r.End.Sub(p.last).Seconds(), exitCode(r.Err)})
+	}
+}
+
+// note returns an alert describing the plan's steps once the run has
+// ended: the order in which they ran, if not as written in the tag,
+// and the step that failed and those left unrun, if the run stopped
+// at a failure.
+func (p *stepPlan) note() string {
+	var b strings.Builder
+	if strings.Join(p.steps, "\n") != strings.Join(splitSteps(p.line), "\n") {
+		fmt.Fprintf(&b, "ran quickest first:\n")
+		for _, s := range p.steps {
+			fmt.Fprintf(&b, "\t%s\n", s)
+		}
+	}
+	if n := len(p.times); n > 0 && n < len(p.steps) {
+		if t := p.times[n-1]; t.ExitCode != 0 {
+			fmt.Fprintf(&b, "%s failed (exit %d); skipped %d more:\n", t.Command, t.ExitCode, len(p.steps)-n)
+			for _, s := range p.steps[n:] {
+				fmt.Fprintf(&b, "\t%s\n", s)
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return b.String() + "\n"
+}
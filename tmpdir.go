@@ -0,0 +1,40 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/hherman1/F/watch"
+)
+
+var tmpPerRun = flag.Bool("tmp", false, "give each run a fresh temporary directory, as $TMPDIR and $F_TMP, removed when the run ends")
+
+// prepareTmp creates run r's temporary directory, if -tmp is set,
+// and passes it to the command.
+func prepareTmp(r *watch.Run) error {
+	if !*tmpPerRun {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "F-run")
+	if err != nil {
+		return err
+	}
+	r.Env = append(r.Env, "TMPDIR="+dir, "F_TMP="+dir)
+	return nil
+}
+
+// removeTmp removes run r's temporary directory, if any.
+func removeTmp(r *watch.Run) {
+	for _, kv := range r.Env {
+		if dir, ok := strings.CutPrefix(kv, "F_TMP="); ok {
+			if err := os.RemoveAll(dir); err != nil {
+				vlogf("remove run directory: %v", err)
+			}
+		}
+	}
+}
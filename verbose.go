@@ -0,0 +1,19 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+)
+
+var verbose = flag.Bool("v", false, "log triggers, the decisions made about them, and window errors to standard error")
+
+// vlogf logs a message if -v is set.
+func vlogf(format string, args ...interface{}) {
+	if *verbose {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hherman1/F/watch"
+)
+
+// lookahead is how many lines of the previous output a new line
+// is compared against before it is taken to be an insertion.
+const lookahead = 50
+
+// Inserted text is held back and written to the window in batches,
+// to avoid a 9P round trip for every line of a chatty command.
+// A batch is written once it reaches flushSize bytes, before any
+// other edit, and at most flushDelay after its first byte.
+const (
+	flushSize  = 8192
+	flushDelay = 50 * time.Millisecond
+)
+
+var writeRate = flag.Int("wps", 20, "write to the window at most `n` times a second (0 for no limit)")
+
+// While a run is slow to produce output, the previous run's output
+// stays in the window, and after bannerDelay a banner line saying so
+// is placed above it. The banner goes as soon as the window is edited.
+const (
+	banner      = "(rerunning; the output below is from the previous run)\n"
+	bannerDelay = 250 * time.Millisecond
+)
+
+// throttleNote follows the output in the window while output
+// is arriving faster than -wps allows it to be written.
+const throttleNote = "(output throttled; more follows)\n"
+
+// A view writes a run's output into the window body by editing
+// the previous run's output in place, rather than clearing the body
+// and writing everything again. Lines that are unchanged from the
+// previous run are left alone, so stable output neither flashes nor
+// loses the scroll position.
+//
+// New output is matched against the old lines in order. A new line
+// equal to the next old line is kept; one matching an old line a little
+// further on deletes the old lines in between; anything else is
+// inserted. When the run ends, any old lines not yet matched are deleted.
+// Whatever edits are chosen, the body ends up holding exactly the new output.
+//
+// A view is only used from the engine's frontend goroutine.
+type view struct {
+	win     window
+	eng     *watch.Engine
+	run     *watch.Run // run whose output this is
+	old     []string   // previous output lines, with newlines
+	cur     int        // index in old of the next line to match
+	q       int        // rune offset in the body of old[cur]
+	partial []byte     // incomplete last line of new output
+
+	pend  []byte // inserted text not yet written
+	pendq int    // rune offset at which pend goes
+	pendn int    // rune count of pend
+	timer *time.Timer
+
+	gap       time.Duration // shortest time between writes, from -wps
+	maxLine   int           // from lineLimit
+	next      time.Time     // earliest time for the next write
+	throttled bool          // a write has been held back by -wps
+	note      int           // rune length of the throttleNote in the body
+	noteq     int           // rune offset of the throttleNote
+
+	banner      int // rune length of the banner at the top of the body
+	bannerTimer *time.Timer
+	alert       int // rune length of the alerts at the top of the body
+}
+
+// begin starts the output of run r.
+func (v *view) begin(r *watch.Run) {
+	v.run = r
+	v.pend = v.pend[:0]
+	v.pendn = 0
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+	v.throttled = false
+	v.note = 0
+	v.gap = 0
+	if *writeRate > 0 {
+		v.gap = time.Second / time.Duration(*writeRate)
+	}
+	v.maxLine = lineLimit()
+	v.alert = 0 // now part of the previous output
+	if *errorsMode {
+		return
+	}
+	v.unbanner()
+	body, err := v.win.ReadAll("body")
+	if err != nil {
+		body = nil
+	}
+	v.old = strings.SplitAfter(string(body), "\n")
+	if len(v.old) > 0 && v.old[len(v.old)-1] == "" {
+		v.old = v.old[:len(v.old)-1]
+	}
+	v.cur = 0
+	v.q = 0
+	v.partial = v.partial[:0]
+	if len(v.old) > 0 {
+		v.bannerTimer = time.AfterFunc(bannerDelay, func() {
+			v.eng.Post(r, v.showBanner)
+		})
+	}
+}
+
+// showBanner places the banner above the previous run's output.
+func (v *view) showBanner() {
+	v.bannerTimer = nil
+	if v.banner > 0 || v.old == nil {
+		return
+	}
+	if v.win.Addr("#0") == nil {
+		if _, err := v.win.Write("data", []byte(banner)); err == nil {
+			v.banner = utf8.RuneCountInString(banner)
+		}
+	}
+}
+
+// showAlert places s above the output of the run just ended,
+// and any earlier alerts, or, with -errors, below it.
+func (v *view) showAlert(s string) {
+	if *errorsMode {
+		v.printf("%s", s)
+		v.flush()
+		return
+	}
+	if v.win.Addr("#0") == nil {
+		if _, err := v.win.Write("data", []byte(s)); err == nil {
+			v.alert += utf8.RuneCountInString(s)
+		}
+	}
+}
+
+// unbanner removes the banner, or cancels it if it is not yet showing.
+// It must be called before any other edit to the body,
+// whose offsets do not count the banner.
+func (v *view) unbanner() {
+	if v.bannerTimer != nil {
+		v.bannerTimer.Stop()
+		v.bannerTimer = nil
+	}
+	if v.banner == 0 {
+		return
+	}
+	if v.win.Addr("#0,#%d", v.banner) == nil {
+		v.win.Write("data", nil)
+	}
+	v.banner = 0
+}
+
+// write adds p to the output.
+func (v *view) write(p []byte) {
+	if *errorsMode {
+		// keep earlier diagnostics; append below them
+		v.insert(string(p))
+		return
+	}
+	v.partial = append(v.partial, p...)
+	for {
+		i := strings.IndexByte(string(v.partial), '\n')
+		if i < 0 {
+			break
+		}
+		v.line(string(v.partial[:i+1]))
+		v.partial = v.partial[i+1:]
+	}
+	if v.maxLine > 0 && len(v.partial) > v.maxLine {
+		// Too long to hold; write it as it is.
+		v.line(string(v.partial))
+		v.partial = v.partial[:0]
+	}
+}
+
+// printf is like write but accepts a printf-style formatting.
+func (v *view) printf(format string, args ...interface{}) {
+	v.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+// end finishes the run's output, deleting what remains of the old.
+func (v *view) end() {
+	if *errorsMode {
+		v.flush()
+		return
+	}
+	if len(v.partial) > 0 {
+		v.line(string(v.partial))
+		v.partial = v.partial[:0]
+	}
+	if v.cur < len(v.old) {
+		v.remove(len(v.old))
+	}
+	v.flush()
+	v.unbanner()
+	v.old = nil
+}
+
+// offset returns the rune offset in the body at which
+// the next complete line of output will be placed.
+func (v *view) offset() int {
+	return v.q
+}
+
+// line places the next line of new output.
+func (v *view) line(s string) {
+	if v.cur < len(v.old) && v.old[v.cur] == s {
+		v.q += utf8.RuneCountInString(s)
+		v.cur++
+		return
+	}
+	for k := v.cur + 1; k < len(v.old) && k <= v.cur+lookahead; k++ {
+		if v.old[k] == s {
+			v.remove(k)
+			v.q += utf8.RuneCountInString(s)
+			v.cur++
+			return
+		}
+	}
+	v.insert(s)
+}
+
+// insert adds s to the body at v.q.
+func (v *view) insert(s string) {
+	if len(v.pend) > 0 && v.pendq+v.pendn != v.q {
+		v.flush()
+	}
+	if len(v.pend) == 0 && v.note > 0 && v.noteq != v.q {
+		v.flush()
+	}
+	if len(v.pend) == 0 {
+		v.pendq = v.q
+	}
+	n := utf8.RuneCountInString(s)
+	v.pend = append(v.pend, s...)
+	v.pendn += n
+	v.q += n
+	if len(v.pend) >= flushSize && !time.Now().Before(v.next) {
+		v.flush()
+		return
+	}
+	if v.timer == nil {
+		v.schedule(flushDelay)
+	}
+}
+
+// schedule arranges for tick to be called after d.
+func (v *view) schedule(d time.Duration) {
+	r := v.run
+	v.timer = time.AfterFunc(d, func() {
+		v.eng.Post(r, v.tick)
+	})
+}
+
+// tick writes held-back text to the window, unless -wps calls for
+// waiting longer. While writes are being held back, the text written
+// is followed by throttleNote, which is removed once output slows.
+func (v *view) tick() {
+	v.timer = nil
+	if wait := time.Until(v.next); wait > 0 {
+		v.throttled = true
+		v.schedule(wait)
+		return
+	}
+	keep := v.throttled && len(v.pend) > 0 && !*errorsMode
+	v.throttled = false
+	v.writePend(keep)
+	if keep {
+		v.schedule(v.gap)
+	}
+}
+
+// flush writes any held-back text to the window,
+// removing the throttleNote if it is showing.
+func (v *view) flush() {
+	v.writePend(false)
+}
+
+// writePend writes any held-back text to the window,
+// followed by throttleNote if note is set.
+func (v *view) writePend(note bool) {
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+	if len(v.pend) == 0 && (v.note == 0 || note) {
+		return
+	}
+	q0, q1 := v.pendq, v.pendq
+	if v.note > 0 {
+		// Any held-back text goes where the note is.
+		q0, q1 = v.noteq, v.noteq+v.note
+	}
+	text := v.pend
+	if note {
+		text = append(text, throttleNote...)
+	}
+	v.unbanner()
+	var err error
+	if *errorsMode {
+		err = v.win.Addr("$")
+	} else {
+		err = v.win.Addr("#%d,#%d", q0, q1)
+	}
+	if err == nil {
+		_, err = v.win.Write("data", text)
+	}
+	if err != nil {
+		vlogf("write window: %v", err)
+	}
+	v.note = 0
+	if note {
+		v.noteq = q0 + v.pendn
+		v.note = utf8.RuneCountInString(throttleNote)
+	}
+	v.pend = v.pend[:0]
+	v.pendn = 0
+	v.next = time.Now().Add(v.gap)
+}
+
+// remove deletes old lines from v.cur up to (but not including) k.
+func (v *view) remove(k int) {
+	v.flush()
+	v.unbanner()
+	n := 0
+	for _, s := range v.old[v.cur:k] {
+		n += utf8.RuneCountInString(s)
+	}
+	err := v.win.Addr("#%d,#%d", v.q, v.q+n)
+	if err == nil {
+		_, err = v.win.Write("data", nil)
+	}
+	if err != nil {
+		vlogf("write window: %v", err)
+	}
+	v.next = time.Now().Add(v.gap)
+	v.cur = k
+}
@@ -0,0 +1,82 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os/exec"
+
+	"github.com/hherman1/F/watch"
+)
+
+var warmCmd = flag.String("warm", "", "after each run, run `cmd` at low priority to warm caches, showing its output only if it fails")
+
+// A warmer runs the -warm command between runs.
+// It is only used from the engine's frontend goroutine.
+type warmer struct {
+	cmd *exec.Cmd // running warm command, if any
+	gen int       // incremented by each start and stop
+}
+
+// start starts the warm command, after run r, using rn's shell.
+// If it fails before the next run begins, its output is added
+// to the end of the window.
+func (wm *warmer) start(rn *Runner) {
+	if *warmCmd == "" || lowPower() {
+		return
+	}
+	wm.gen++
+	gen := wm.gen
+	var out bytes.Buffer
+	cmd := exec.Command(rn.eng.Shell, "-c", *warmCmd)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	watch.Isolate(cmd)
+	if err := cmd.Start(); err != nil {
+		vlogf("warm: %v", err)
+		return
+	}
+	lowPriority(cmd.Process.Pid)
+	wm.cmd = cmd
+	go func() {
+		err := cmd.Wait()
+		rn.eng.Post(nil, func() {
+			if wm.gen != gen {
+				return // stopped
+			}
+			wm.cmd = nil
+			if err == nil {
+				return
+			}
+			text := fmt.Sprintf("%% %s\n%s", *warmCmd, out.Bytes())
+			if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+				text += "\\\n"
+			}
+			text += fmt.Sprintf("(%v)\n", err)
+			rn.win.Addr("$")
+			rn.win.Write("data", []byte(text))
+			rn.mu.Lock()
+			rn.mark()
+			rn.mu.Unlock()
+		})
+	}()
+}
+
+// stop kills the warm command, if it is running,
+// in the background unless wait is set.
+func (wm *warmer) stop(wait bool) {
+	wm.gen++
+	if wm.cmd == nil {
+		return
+	}
+	if wait {
+		watch.Stop(wm.cmd)
+	} else {
+		go watch.Stop(wm.cmd)
+	}
+	wm.cmd = nil
+}
@@ -0,0 +1,15 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!solaris
+
+package watch
+
+import "io/fs"
+
+// device returns the device holding the file described by info.
+// It is not known on this system.
+func device(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,34 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// Warn is called to report problems that Files works around,
+// such as having to poll directories that inotify cannot watch.
+var Warn = log.Printf
+
+// Walk calls fn for each file in root that Files would watch,
+// with its file information.
+func Walk(root string, recursive bool, fn func(name string, info fs.FileInfo)) error {
+	return walkTree(root, root, recursive, func(string, int) error { return nil }, fn)
+}
+
+// skipDir reports whether Files should ignore the directory dir,
+// which is inside root. Hidden directories such as .git are skipped.
+func skipDir(root, dir string, recursive bool) bool {
+	if dir == root {
+		return false
+	}
+	if !recursive {
+		return true
+	}
+	return strings.HasPrefix(filepath.Base(dir), ".")
+}
@@ -0,0 +1,176 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyAddWatch adds an inotify watch; tests replace it to run out
+// of watches.
+var inotifyAddWatch = syscall.InotifyAddWatch
+
+// Remote file systems, whose changes inotify does not see.
+var remoteFS = map[int64]string{
+	0x01021997: "v9fs",
+	0x65735546: "fuse", // 9pfuse, sshfs, and the like
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+}
+
+// Files calls changed for each change to a file in root, or, if
+// recursive is set, in any subdirectory of root, using inotify(7),
+// or, if root is on a remote file system, by polling as Poll does.
+// It watches in the background, returning an error only if the
+// watch cannot be set up.
+//
+// If LazyDepth is set, Files returns once it is watching that many
+// levels of directories, and watches deeper ones in the background,
+// starting with those below the directories most recently changed.
+// Until then changes in the deeper directories are missed.
+func Files(root string, recursive bool, changed func(name string)) error {
+	var st syscall.Statfs_t
+	if syscall.Statfs(root, &st) == nil && remoteFS[int64(st.Type)] != "" {
+		return Poll(root, recursive, pollInterval, changed)
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	lazy := LazyDepth
+	var (
+		mu       sync.Mutex
+		dirs     = make(map[int]string)
+		frontier []string // watched directories with subdirectories not yet watched
+		hot      string   // directory of the latest change
+	)
+	// add watches dir and, to LazyDepth levels, the directories below it.
+	// If expand is set, dir is already watched.
+	add := func(dir string, expand bool) error {
+		var unwatched []string
+		err := walkTree(root, dir, recursive, func(path string, depth int) error {
+			if !expand || depth > 0 {
+				wd, err := inotifyAddWatch(fd, path, inotifyMask)
+				if err == syscall.ENOSPC {
+					// Out of watches: poll this subtree instead.
+					unwatched = append(unwatched, path)
+					return filepath.SkipDir
+				}
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				_, dup := dirs[wd]
+				dirs[wd] = path
+				mu.Unlock()
+				if dup {
+					return filepath.SkipDir // watched already, by another name
+				}
+			}
+			if lazy > 0 && depth >= lazy {
+				mu.Lock()
+				frontier = append(frontier, path)
+				mu.Unlock()
+				return filepath.SkipDir
+			}
+			return nil
+		}, nil)
+		if err != nil || len(unwatched) == 0 {
+			return err
+		}
+		Warn("inotify watch limit reached; polling %s instead (raise the limit with sysctl fs.inotify.max_user_watches=524288)", strings.Join(unwatched, " "))
+		for _, dir := range unwatched {
+			if err := Poll(dir, recursive, pollInterval, changed); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := add(root, false); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	if lazy > 0 {
+		go func() {
+			for {
+				mu.Lock()
+				if len(frontier) == 0 {
+					mu.Unlock()
+					time.Sleep(time.Second)
+					continue
+				}
+				i := 0
+				for j, d := range frontier {
+					if hot != "" && (d == hot || strings.HasPrefix(d, hot+"/")) {
+						i = j
+						break
+					}
+				}
+				dir := frontier[i]
+				frontier = append(frontier[:i], frontier[i+1:]...)
+				mu.Unlock()
+				add(dir, true)
+			}
+		}()
+	}
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				nameb := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				off += syscall.SizeofInotifyEvent + int(ev.Len)
+				mu.Lock()
+				dir, ok := dirs[int(ev.Wd)]
+				if ok && ev.Mask&syscall.IN_IGNORED != 0 {
+					delete(dirs, int(ev.Wd))
+					ok = false
+				}
+				if ok {
+					hot = dir
+				}
+				mu.Unlock()
+				if !ok {
+					continue
+				}
+				path := filepath.Join(dir, cstring(nameb))
+				if ev.Mask&syscall.IN_ISDIR != 0 {
+					if ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+						add(path, false)
+					}
+					continue
+				}
+				changed(path)
+			}
+		}
+	}()
+	return nil
+}
+
+// cstring returns the NUL-terminated string at the start of b.
+func cstring(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,58 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFilesWatchLimit(t *testing.T) {
+	root := t.TempDir()
+	big := filepath.Join(root, "big")
+	deep := filepath.Join(big, "sub")
+	os.MkdirAll(deep, 0777)
+	defer func(f func(int, string, uint32) (int, error)) { inotifyAddWatch = f }(inotifyAddWatch)
+	inotifyAddWatch = func(fd int, path string, mask uint32) (int, error) {
+		if path == big {
+			return -1, syscall.ENOSPC
+		}
+		return syscall.InotifyAddWatch(fd, path, mask)
+	}
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = 20 * time.Millisecond
+	defer func(f func(string, ...interface{})) { Warn = f }(Warn)
+	var warning string
+	Warn = func(format string, args ...interface{}) { warning = fmt.Sprintf(format, args...) }
+
+	changes := make(chan string, 10)
+	if err := Files(root, true, func(name string) { changes <- name }); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warning, "polling "+big+" instead") || !strings.Contains(warning, "sysctl fs.inotify.max_user_watches") {
+		t.Errorf("warning = %q, want it to name %s and the sysctl", warning, big)
+	}
+	for _, name := range []string{filepath.Join(root, "a"), filepath.Join(deep, "b")} {
+		if err := os.WriteFile(name, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	wait:
+		for {
+			select {
+			case got := <-changes:
+				if got == name {
+					break wait
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("change to %s missed", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package watch
+
+// Files calls changed for each change to a file in root, or, if
+// recursive is set, in any subdirectory of root, by rescanning the
+// tree every pollInterval. It watches in the background, returning
+// an error only if the watch cannot be set up.
+func Files(root string, recursive bool, changed func(name string)) error {
+	return Poll(root, recursive, pollInterval, changed)
+}
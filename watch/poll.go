@@ -0,0 +1,136 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often Files rescans the tree when it polls.
+var pollInterval = time.Second
+
+type fileStamp struct {
+	mtime time.Time
+	size  int64
+}
+
+// A pollRoot is a tree being polled.
+type pollRoot struct {
+	dir       string
+	recursive bool
+}
+
+// polls coordinates the polls with the engine. A poll compares each
+// scan only with the one before, never with the clock, as a remote
+// file server's clock may not agree with this machine's. Instead, as
+// each run ends, settle has every poll rescan and take the files as
+// they are then as its baseline, so that changes the run made itself
+// are not taken for new ones.
+var polls struct {
+	sync.Mutex
+	gen   int           // number of settles
+	wake  chan struct{} // closed by the next settle
+	roots map[pollRoot]bool
+}
+
+// settle has every poll rescan without reporting what changed.
+func settle() {
+	polls.Lock()
+	defer polls.Unlock()
+	polls.gen++
+	if polls.wake != nil {
+		close(polls.wake)
+		polls.wake = nil
+	}
+}
+
+// settled returns the number of settles so far and a channel
+// closed by the next one.
+func settled() (gen int, wake <-chan struct{}) {
+	polls.Lock()
+	defer polls.Unlock()
+	if polls.wake == nil {
+		polls.wake = make(chan struct{})
+	}
+	return polls.gen, polls.wake
+}
+
+// polled reports whether the file name is in a tree being polled.
+func polled(name string) bool {
+	polls.Lock()
+	defer polls.Unlock()
+	for r := range polls.roots {
+		if filepath.Dir(name) == r.dir || r.recursive && strings.HasPrefix(name, r.dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Poll calls changed for each change to a file in root, or, if
+// recursive is set, in any subdirectory of root, by rescanning the
+// tree every interval. It works on any file system, including remote
+// ones where inotify(7) sees nothing, such as a 9P mount. It watches
+// in the background, returning an error only if the watch cannot be
+// set up.
+func Poll(root string, recursive bool, interval time.Duration, changed func(name string)) error {
+	last, _ := settled()
+	old, err := scanFiles(root, recursive)
+	if err != nil {
+		return err
+	}
+	polls.Lock()
+	if polls.roots == nil {
+		polls.roots = make(map[pollRoot]bool)
+	}
+	polls.roots[pollRoot{root, recursive}] = true
+	polls.Unlock()
+	go func() {
+		for {
+			gen, wake := settled()
+			if gen == last {
+				select {
+				case <-time.After(interval):
+				case <-wake:
+				}
+			}
+			before, _ := settled()
+			cur, err := scanFiles(root, recursive)
+			if err != nil {
+				continue
+			}
+			after, _ := settled()
+			if before == last && after == last {
+				for name, st := range cur {
+					if o, ok := old[name]; !ok || o != st {
+						changed(name)
+					}
+				}
+				for name := range old {
+					if _, ok := cur[name]; !ok {
+						changed(name)
+					}
+				}
+			}
+			// Otherwise a run ended since the last scan, or during this
+			// one, and this scan is the baseline for changes after it.
+			last = after
+			old = cur
+		}
+	}()
+	return nil
+}
+
+func scanFiles(root string, recursive bool) (map[string]fileStamp, error) {
+	files := make(map[string]fileStamp)
+	err := Walk(root, recursive, func(path string, info fs.FileInfo) {
+		files[path] = fileStamp{info.ModTime(), info.Size()}
+	})
+	return files, err
+}
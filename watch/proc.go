@@ -0,0 +1,64 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// Each system provides these ways to act on a command, and the
+// processes it starts, once isolate has been called before it started:
+//
+//	adopt(cmd)       takes charge of cmd once it has started
+//	release(cmd)     lets go of cmd once it has exited
+//	interrupt(cmd)   asks cmd, gently, to stop
+//	terminate(cmd)   tells cmd to stop, reporting whether the system can
+//	dump(cmd)        asks cmd to dump its state and exit, as Go programs do
+//	                 on SIGQUIT, reporting whether the system can
+//	forceKill(cmd)   stops cmd without asking
+
+// ErrNoDump is returned by Quit on systems with no way to ask
+// a command to dump its state.
+var ErrNoDump = errors.New("no way to ask a command to dump its state on " + runtime.GOOS)
+
+// killStep is how long kill gives each of its steps to work.
+const killStep = 100 * time.Millisecond
+
+// kill stops cmd, first asking and then forcing it.
+func kill(cmd *exec.Cmd) {
+	if cmd.Process.Pid <= 0 {
+		return
+	}
+	interrupt(cmd)
+	time.Sleep(killStep)
+	if terminate(cmd) {
+		time.Sleep(killStep)
+	}
+	forceKill(cmd)
+}
+
+// Isolate arranges for cmd, once started, to run in its own process
+// group (or note group), so that Stop reaches the processes it starts.
+// It must be called before cmd is started.
+func Isolate(cmd *exec.Cmd) {
+	isolate(cmd)
+}
+
+// Stop kills cmd, which must have been started, the way
+// the engine kills a run. It returns once the signals are sent.
+func Stop(cmd *exec.Cmd) {
+	kill(cmd)
+}
+
+// Interrupt asks cmd, which must have been started, to stop, as Stop
+// does first, but without going on to force it.
+func Interrupt(cmd *exec.Cmd) {
+	if cmd.Process.Pid > 0 {
+		interrupt(cmd)
+	}
+}
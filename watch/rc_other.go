@@ -0,0 +1,28 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !plan9
+
+package watch
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// RC returns the path of the plan9port rc.
+func RC() string {
+	// There may be a different rc in the PATH,
+	// but there probably won't be a different 9.
+	// Don't just invoke 9, because it will change
+	// the PATH.
+	if dir := os.Getenv("PLAN9"); dir != "" {
+		return filepath.Join(dir, "bin/rc")
+	}
+	if nine, err := exec.LookPath("9"); err == nil {
+		return filepath.Join(filepath.Dir(nine), "rc")
+	}
+	return "/usr/local/plan9/bin/rc"
+}
@@ -0,0 +1,10 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+// RC returns the path of the system rc.
+func RC() string {
+	return "/bin/rc"
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!solaris,!plan9,!windows
+
+package watch
+
+import (
+	"os"
+	"os/exec"
+)
+
+func isolate(cmd *exec.Cmd) {}
+func adopt(cmd *exec.Cmd)   {}
+func release(cmd *exec.Cmd) {}
+
+func interrupt(cmd *exec.Cmd) {
+	cmd.Process.Signal(os.Interrupt)
+}
+
+func terminate(cmd *exec.Cmd) bool {
+	return false
+}
+
+func dump(cmd *exec.Cmd) bool {
+	return false
+}
+
+func forceKill(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolate puts the command in its own note group,
+// so that notes posted to the group do not reach F.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Rfork: syscall.RFNOTEG,
+	}
+}
+
+func adopt(cmd *exec.Cmd)   {}
+func release(cmd *exec.Cmd) {}
+
+func interrupt(cmd *exec.Cmd) {
+	postnote(cmd.Process.Pid, "interrupt")
+}
+
+// Plan 9 has no note between interrupt and kill.
+func terminate(cmd *exec.Cmd) bool {
+	return false
+}
+
+// Plan 9 has no equivalent of SIGQUIT: Go programs treat
+// notes other than interrupt and hangup as fatal, without a dump.
+func dump(cmd *exec.Cmd) bool {
+	return false
+}
+
+func forceKill(cmd *exec.Cmd) {
+	postnote(cmd.Process.Pid, "kill")
+}
+
+// postnote posts note to the note group of process pid.
+func postnote(pid int, note string) {
+	if pid <= 0 {
+		return
+	}
+	f, err := os.OpenFile(fmt.Sprintf("/proc/%d/notepg", pid), os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	f.Write([]byte(note))
+	f.Close()
+}
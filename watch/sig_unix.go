@@ -0,0 +1,50 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin freebsd netbsd openbsd solaris
+
+package watch
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// isolate puts the command in its own process group,
+// so that signals sent to the group reach everything it starts.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+}
+
+func adopt(cmd *exec.Cmd)   {}
+func release(cmd *exec.Cmd) {}
+
+// signal sends sig to the process group of cmd.
+func signal(cmd *exec.Cmd, sig syscall.Signal) {
+	pid := cmd.Process.Pid
+	if pid <= 0 {
+		return
+	}
+	syscall.Kill(-pid, sig)
+}
+
+func interrupt(cmd *exec.Cmd) {
+	signal(cmd, syscall.SIGINT)
+}
+
+func terminate(cmd *exec.Cmd) bool {
+	signal(cmd, syscall.SIGTERM)
+	return true
+}
+
+func dump(cmd *exec.Cmd) bool {
+	signal(cmd, syscall.SIGQUIT)
+	return true
+}
+
+func forceKill(cmd *exec.Cmd) {
+	signal(cmd, syscall.SIGKILL)
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procGenerateConsoleCtrlEvent = kernel32.NewProc("GenerateConsoleCtrlEvent")
+	procCreateJobObject          = kernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+)
+
+const (
+	ctrlBreakEvent  = 1
+	processSetQuota = 0x0100
+)
+
+// jobs holds the job object of each command adopted,
+// through which forceKill stops the processes it started.
+var jobs struct {
+	sync.Mutex
+	m map[*exec.Cmd]syscall.Handle
+}
+
+// isolate starts the command in a new process group,
+// so that a CTRL_BREAK sent to the group does not reach F.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// adopt puts cmd in a new job object, whose processes forceKill
+// can stop together. Processes cmd starts before it is adopted,
+// in the moment after it starts, are not in the job.
+func adopt(cmd *exec.Cmd) {
+	job, _, _ := procCreateJobObject.Call(0, 0)
+	if job == 0 {
+		return
+	}
+	h, err := syscall.OpenProcess(syscall.PROCESS_TERMINATE|processSetQuota, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		syscall.CloseHandle(syscall.Handle(job))
+		return
+	}
+	defer syscall.CloseHandle(h)
+	if ok, _, _ := procAssignProcessToJobObject.Call(job, uintptr(h)); ok == 0 {
+		syscall.CloseHandle(syscall.Handle(job))
+		return
+	}
+	jobs.Lock()
+	if jobs.m == nil {
+		jobs.m = make(map[*exec.Cmd]syscall.Handle)
+	}
+	jobs.m[cmd] = syscall.Handle(job)
+	jobs.Unlock()
+}
+
+func release(cmd *exec.Cmd) {
+	jobs.Lock()
+	job, ok := jobs.m[cmd]
+	delete(jobs.m, cmd)
+	jobs.Unlock()
+	if ok {
+		syscall.CloseHandle(job)
+	}
+}
+
+// interrupt sends a CTRL_BREAK to the process group of cmd.
+// It reaches cmd only if cmd shares F's console.
+func interrupt(cmd *exec.Cmd) {
+	procGenerateConsoleCtrlEvent.Call(ctrlBreakEvent, uintptr(cmd.Process.Pid))
+}
+
+// Windows has no signal between CTRL_BREAK and termination.
+func terminate(cmd *exec.Cmd) bool {
+	return false
+}
+
+// Go programs on Windows take CTRL_BREAK as an interrupt,
+// and there is no other way to ask for a dump.
+func dump(cmd *exec.Cmd) bool {
+	return false
+}
+
+// forceKill terminates the processes in the job of cmd,
+// or just cmd if it was not adopted.
+func forceKill(cmd *exec.Cmd) {
+	jobs.Lock()
+	job, ok := jobs.m[cmd]
+	jobs.Unlock()
+	if ok {
+		if done, _, _ := procTerminateJobObject.Call(uintptr(job), 1); done != 0 {
+			return
+		}
+	}
+	cmd.Process.Kill()
+}
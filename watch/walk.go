@@ -0,0 +1,106 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FollowSymlinks makes Files, Poll, and Walk follow symbolic links
+// to directories. A link back to a directory being walked is not
+// followed, so that loops end.
+var FollowSymlinks bool
+
+// LazyDepth, if positive, is how many levels of directories below
+// root Files watches before returning; see Files.
+var LazyDepth int
+
+// OneFileSystem keeps Files, Poll, and Walk from descending into
+// directories on file systems other than root's, like find -xdev.
+var OneFileSystem bool
+
+// walkTree walks the tree that Files watches in root, starting at
+// start, which is root or a directory in it. It calls dir for each
+// directory, with its depth below start, skipping the directory's
+// contents if dir returns filepath.SkipDir, and, if file is non-nil,
+// calls file for each file.
+func walkTree(root, start string, recursive bool, dir func(path string, depth int) error, file func(path string, info fs.FileInfo)) error {
+	if skipDir(root, start, recursive) {
+		return nil
+	}
+	info, err := os.Stat(start)
+	if err != nil {
+		return err
+	}
+	var rootDev uint64
+	if OneFileSystem {
+		rinfo, err := os.Stat(root)
+		if err != nil {
+			return err
+		}
+		rootDev, _ = device(rinfo)
+	}
+	var visit func(path string, info fs.FileInfo, ancestors []fs.FileInfo) error
+	visit = func(path string, info fs.FileInfo, ancestors []fs.FileInfo) error {
+		for _, a := range ancestors {
+			if os.SameFile(a, info) {
+				return nil // a loop
+			}
+		}
+		if OneFileSystem && path != root {
+			if dev, ok := device(info); ok && dev != rootDev {
+				return nil
+			}
+		}
+		if err := dir(path, len(ancestors)); err != nil {
+			if err == filepath.SkipDir {
+				return nil
+			}
+			return err
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			if path == start {
+				return err
+			}
+			return nil
+		}
+		ancestors = append(ancestors[:len(ancestors):len(ancestors)], info)
+		for _, e := range entries {
+			p := filepath.Join(path, e.Name())
+			isDir := e.IsDir()
+			if e.Type()&fs.ModeSymlink != 0 && FollowSymlinks {
+				if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+					isDir = true
+				}
+			}
+			if isDir {
+				if skipDir(root, p, recursive) {
+					continue
+				}
+				fi, err := os.Stat(p)
+				if err != nil {
+					continue
+				}
+				if err := visit(p, fi, ancestors); err != nil {
+					return err
+				}
+				continue
+			}
+			if file == nil {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil {
+				continue
+			}
+			file(p, fi)
+		}
+		return nil
+	}
+	return visit(start, info, nil)
+}
@@ -0,0 +1,698 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package watch is the engine behind F: it runs a command line each
+// time it is triggered, killing any run still in progress, and streams
+// each run's output to a Frontend, such as F's acme window.
+//
+// Triggers may come from anywhere; the package provides a file system
+// watcher (Files) as one source. Triggers that arrive while a run is
+// pending are coalesced into it. A storm of triggers, such as from a
+// git checkout touching thousands of files, is collapsed into a single
+// run once the triggers stop.
+//
+// A run is killed, along with the processes it started, by asking and
+// then forcing it to stop, 100ms apart. On Unix its process group is
+// sent a SIGINT, a SIGTERM, and a SIGKILL; on Windows its process group
+// is sent a CTRL_BREAK and then its job object is terminated; and on
+// Plan 9 its note group is sent an interrupt note and then a kill note.
+// Elsewhere, only the command's own process is sent os.Interrupt and
+// then os.Kill.
+package watch // import "github.com/hherman1/F/watch"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Trigger describes what caused a run.
+type Trigger struct {
+	Kind string // for example "start", "put", or "file"
+	File string // file written, if any
+	Arg  string // argument for the command, if any
+	Win  int    // ID of the acme window written, if any
+}
+
+// A Run is a single execution of the command line.
+type Run struct {
+	ID      int
+	Trigger Trigger
+	Line    string   // command line, passed to the shell
+	Shell   string   // the shell, run as Shell -c Line
+	Env     []string // additions to the environment
+	Start   time.Time
+	End     time.Time // when the command exited
+
+	// Started reports whether the command was started.
+	// If not, Err is the reason.
+	Started bool
+	// Err is the error returned by waiting for the command.
+	Err error
+	// Superseded reports that a later run began before this one ended.
+	// Its output may have been cut short.
+	Superseded bool
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	cmd    *exec.Cmd // set once started; guarded by the engine's mu
+	begun  bool      // Begin has been called; used only from the frontend goroutine
+}
+
+// Errors recording why a run was canceled; see Run.Context.
+var (
+	ErrKilled     = errors.New("killed")
+	ErrSuperseded = errors.New("superseded")
+	ErrTimeout    = errors.New("timed out")
+)
+
+// Context returns the run's context. It is canceled, with cause
+// ErrKilled, ErrSuperseded, or ErrTimeout, when the run is killed,
+// a later run begins, or the run outlasts the engine's Timeout.
+func (r *Run) Context() context.Context {
+	return r.ctx
+}
+
+// superseded reports whether a later run has begun.
+func (r *Run) superseded() bool {
+	return context.Cause(r.ctx) == ErrSuperseded
+}
+
+// A Frontend displays runs.
+//
+// The engine calls a Frontend's methods from a single goroutine,
+// one at a time and in order, so a slow frontend never holds up the
+// engine itself. Every run whose Begin is called gets an End, with its
+// Output in between. Once Begin has been called for a run, earlier runs
+// receive no further Output. A run superseded before its Begin could be
+// called gets neither Begin nor End; the engine's Discard is called
+// for it instead.
+type Frontend interface {
+	// Begin is called when run r is about to start.
+	// Only r's ID, Trigger, Line, and Start are set.
+	Begin(r *Run)
+	// Output is called with each piece of r's output.
+	// The frontend may keep p.
+	Output(r *Run, p []byte)
+	// End is called when r has exited or failed to start.
+	End(r *Run)
+}
+
+// An Engine runs a command line each time it is triggered.
+type Engine struct {
+	// Shell is the shell used to run each command line, as Shell -c line.
+	// Once Run has been called, it may only be changed with SetShell.
+	Shell string
+
+	// Command returns the command line for a new run.
+	Command func() (string, error)
+
+	// Prepare, if non-nil, is called before each run begins
+	// and may change its Line and Env.
+	Prepare func(r *Run) error
+
+	// Frontend displays the runs.
+	Frontend Frontend
+
+	// Timeout, if non-zero, is how long a run may last
+	// before it is killed.
+	Timeout time.Duration
+
+	// Delay is how long to wait after a trigger before running.
+	// Each further trigger restarts the wait.
+	// Once Run has been called, it may only be changed with SetDelay.
+	Delay time.Duration
+
+	// Kicked, if non-nil, is called with each trigger passed to Kick.
+	Kicked func(t Trigger)
+
+	// Discard, if non-nil, is called from the frontend goroutine for
+	// each run that was superseded before its Begin could be called,
+	// so that anything Prepare set up for it can be released.
+	Discard func(r *Run)
+
+	// Started, if non-nil, is called with the process ID of
+	// each run's command once it has started.
+	Started func(r *Run, pid int)
+
+	// StormRate is the number of triggers a second above which
+	// the engine waits for StormQuiet without triggers before
+	// running. If it is zero, there is no limit.
+	StormRate  int
+	StormQuiet time.Duration
+
+	// Recover, if non-nil, is called when Command, Prepare, or a
+	// Frontend method panics, with what was being done, the value
+	// passed to panic, and the stack. The engine then carries on:
+	// a run whose Command or Prepare panicked fails with an error.
+	// If Recover is nil, such panics are not recovered.
+	Recover func(where string, v interface{}, stack []byte)
+
+	// Logf, if non-nil, is called to log triggers, the decisions
+	// made about them, and the signals sent to runs.
+	Logf func(format string, args ...interface{})
+
+	needrun chan Trigger
+	ops     chan op
+	done    chan struct{} // closed by Stop
+	exited  chan struct{} // closed when the frontend goroutine returns
+	runs    sync.WaitGroup
+
+	mu    sync.Mutex
+	kicks []time.Time // times of triggers in the last second
+	id    int
+	cur   *Run      // latest run begun
+	line  string    // most recent command line
+	end   time.Time // end of the last run, or zero before the first ends
+	busy  bool      // the current run has not yet ended
+
+	changed map[string]bool // files changed during the current run
+	wrote   map[string]bool // files changed during the last run to end
+
+	started bool // Run has been called
+	stopped bool // Stop has been called
+}
+
+// New returns an engine that runs the command lines returned by command,
+// using rc, and displays them with fe.
+func New(fe Frontend, command func() (string, error)) *Engine {
+	return &Engine{
+		Shell:      RC(),
+		Command:    command,
+		Frontend:   fe,
+		StormQuiet: 500 * time.Millisecond,
+		needrun:    make(chan Trigger, 1),
+		ops:        make(chan op, 256),
+		done:       make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+}
+
+// Kick requests a run for t, unless one is already pending.
+func (e *Engine) Kick(t Trigger) {
+	e.mu.Lock()
+	e.count()
+	e.mu.Unlock()
+	e.kick(t)
+}
+
+// count records a trigger for the storm detector. e.mu must be held.
+func (e *Engine) count() {
+	now := time.Now()
+	i := 0
+	for i < len(e.kicks) && now.Sub(e.kicks[i]) > time.Second {
+		i++
+	}
+	e.kicks = append(e.kicks[i:], now)
+}
+
+// kick requests a run for t, as Kick does, without counting it.
+func (e *Engine) kick(t Trigger) {
+	if e.Kicked != nil {
+		e.Kicked(t)
+	}
+	select {
+	case e.needrun <- t:
+		e.logf("trigger %s %s: run queued", t.Kind, t.File)
+	default:
+		e.logf("trigger %s %s: run already pending", t.Kind, t.File)
+	}
+}
+
+// rerun requests a run for t, as Kick does, unless a run has begun
+// since r. The check and the request are made together so that a
+// run being created, which takes any pending trigger, cannot miss
+// it or be followed by another one for it.
+func (e *Engine) rerun(r *Run, t Trigger) {
+	e.mu.Lock()
+	queued := false
+	if e.cur == r {
+		e.count()
+		select {
+		case e.needrun <- t:
+			queued = true
+		default:
+		}
+	}
+	e.mu.Unlock()
+	if queued {
+		if e.Kicked != nil {
+			e.Kicked(t)
+		}
+		e.logf("trigger %s %s: run queued", t.Kind, t.File)
+	}
+}
+
+func (e *Engine) logf(format string, args ...interface{}) {
+	if e.Logf != nil {
+		e.Logf(format, args...)
+	}
+}
+
+// Run processes triggers until the program exits or Stop is called.
+func (e *Engine) Run() {
+	e.mu.Lock()
+	if e.stopped {
+		e.mu.Unlock()
+		return
+	}
+	e.started = true
+	e.mu.Unlock()
+	go e.frontend()
+	for {
+		var t Trigger
+		select {
+		case t = <-e.needrun:
+		case <-e.done:
+			return
+		}
+		e.mu.Lock()
+		quiet := e.Delay
+		e.mu.Unlock()
+		if e.storming() && e.StormQuiet > quiet {
+			e.logf("trigger storm: waiting for %v without triggers", e.StormQuiet)
+			quiet = e.StormQuiet
+		}
+		if quiet > 0 {
+			t = e.waitQuiet(t, quiet)
+		}
+		e.mu.Lock()
+		if e.stopped {
+			e.mu.Unlock()
+			return
+		}
+		select {
+		case t = <-e.needrun: // seen by the run about to begin
+		default:
+		}
+		e.id++
+		r := &Run{ID: e.id, Trigger: t, Start: time.Now()}
+		r.ctx, r.cancel = context.WithCancelCause(context.Background())
+		if e.cur != nil {
+			e.cur.cancel(ErrSuperseded)
+		}
+		if e.busy {
+			// What the superseded run changed is taken as its output.
+			if e.wrote == nil {
+				e.wrote = make(map[string]bool)
+			}
+			for name := range e.changed {
+				e.wrote[name] = true
+			}
+		}
+		e.cur = r
+		e.busy = true
+		e.changed = nil // seen by r
+		e.runs.Add(1)
+		e.mu.Unlock()
+
+		go func() {
+			defer e.runs.Done()
+			e.run(r)
+		}()
+	}
+}
+
+// Stop kills the current run and stops the engine. It returns once the
+// run has ended and the frontend has been handed everything queued for
+// it; after that, the engine starts no runs and calls the frontend no
+// more. It must not be called from the frontend goroutine.
+func (e *Engine) Stop() {
+	e.mu.Lock()
+	if e.stopped {
+		e.mu.Unlock()
+		return
+	}
+	e.stopped = true
+	started := e.started
+	r := e.cur
+	e.mu.Unlock()
+	if r != nil {
+		r.cancel(ErrKilled)
+	}
+	e.runs.Wait()
+	close(e.done)
+	if started {
+		<-e.exited
+	}
+}
+
+// SetShell changes the engine's Shell, from the next run on.
+func (e *Engine) SetShell(shell string) {
+	e.mu.Lock()
+	e.Shell = shell
+	e.mu.Unlock()
+}
+
+// SetDelay changes the engine's Delay.
+func (e *Engine) SetDelay(d time.Duration) {
+	e.mu.Lock()
+	e.Delay = d
+	e.mu.Unlock()
+}
+
+// storming reports whether triggers are arriving faster than StormRate.
+func (e *Engine) storming() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stormingLocked()
+}
+
+// stormingLocked is storming with e.mu held.
+func (e *Engine) stormingLocked() bool {
+	return e.StormRate > 0 && len(e.kicks) > e.StormRate
+}
+
+// waitQuiet waits until there has been no trigger for d,
+// and returns the last trigger received, starting with t.
+func (e *Engine) waitQuiet(t Trigger, d time.Duration) Trigger {
+	for {
+		e.mu.Lock()
+		last := e.kicks[len(e.kicks)-1]
+		e.mu.Unlock()
+		wait := time.Until(last.Add(d))
+		if wait <= 0 {
+			break
+		}
+		time.Sleep(wait)
+	}
+	select {
+	case t = <-e.needrun:
+	default:
+	}
+	return t
+}
+
+// Kill stops the current run.
+func (e *Engine) Kill() {
+	e.mu.Lock()
+	r := e.cur
+	e.mu.Unlock()
+	if r != nil {
+		e.logf("run %d: kill requested", r.ID)
+		r.cancel(ErrKilled)
+	}
+}
+
+// Quit asks the current run to dump its state and exit: on Unix, it
+// sends a SIGQUIT, on which Go programs dump their goroutine stacks.
+// It returns ErrNoDump on systems with no way to ask that.
+func (e *Engine) Quit() error {
+	e.mu.Lock()
+	var cmd *exec.Cmd
+	if e.cur != nil {
+		cmd = e.cur.cmd
+	}
+	e.mu.Unlock()
+	if cmd == nil {
+		return nil
+	}
+	e.logf("sending quit to pid %d", cmd.Process.Pid)
+	if !dump(cmd) {
+		return ErrNoDump
+	}
+	return nil
+}
+
+// Line returns the most recently run command line.
+func (e *Engine) Line() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.line
+}
+
+// FileChanged triggers a run because the named file changed on disk.
+//
+// Changes from before the last run started were already seen by it,
+// so they are ignored; the file's modification time tells which,
+// except in a tree being polled, which may be on a file server with
+// a clock of its own and where Poll has left out the run's changes.
+//
+// Changes made while a run is going (including while the frontend is
+// ending it) may be its own output (go generate, gofmt -w, and the
+// like), which would trigger runs forever, or may be edits made
+// meanwhile. Once the run ends, a file it changed triggers one more
+// run, unless the run before it changed the file too: a command that
+// writes the same files each time settles after one rerun.
+//
+// Changes count toward StormRate whether or not a run is going,
+// except for those to files taken as the run's output. A storm during
+// a run collapses into a single run, superseding it, once the storm
+// stops.
+func (e *Engine) FileChanged(name string) {
+	e.mu.Lock()
+	busy := e.busy
+	end := e.end
+	storm := false
+	if busy {
+		if e.changed == nil {
+			e.changed = make(map[string]bool)
+		}
+		e.changed[name] = true
+		if !e.wrote[name] {
+			e.count()
+			storm = e.stormingLocked()
+		}
+	}
+	e.mu.Unlock()
+	if storm {
+		e.logf("file %s changed during a run: trigger storm", name)
+		e.kick(Trigger{Kind: "file", File: name})
+		return
+	}
+	if busy {
+		e.logf("file %s changed during a run: checked once it ends", name)
+		return
+	}
+	if polled(name) {
+		e.Kick(Trigger{Kind: "file", File: name})
+		return
+	}
+	if info, err := os.Lstat(name); err == nil && !end.IsZero() && !info.ModTime().After(end) {
+		e.logf("file %s not modified since the last run: ignored", name)
+		return
+	}
+	e.Kick(Trigger{Kind: "file", File: name})
+}
+
+// Post arranges for f to be called from the frontend goroutine,
+// after any calls already queued, unless a later run has begun by then.
+// If r is nil, f is always called.
+// Once the engine has stopped, f is not called.
+func (e *Engine) Post(r *Run, f func()) {
+	select {
+	case e.ops <- op{r: r, f: f}:
+	case <-e.done:
+	}
+}
+
+// Done returns a channel that is closed once the engine has stopped,
+// for waiting on a call passed to Post that may never be made.
+func (e *Engine) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *Engine) run(r *Run) {
+	e.mu.Lock()
+	r.Shell = e.Shell
+	e.mu.Unlock()
+	r.Line, r.Err = e.command()
+	if r.Err == nil {
+		e.mu.Lock()
+		e.line = r.Line
+		e.mu.Unlock()
+		if e.Prepare != nil {
+			r.Err = e.prepare(r)
+		}
+	}
+	e.Post(r, func() {
+		r.begun = true
+		e.Frontend.Begin(r)
+	})
+	if r.Err != nil {
+		e.finish(r)
+		return
+	}
+
+	e.logf("run %d: %s -c %q", r.ID, r.Shell, r.Line)
+	cmd := exec.Command(r.Shell, "-c", r.Line)
+	if r.Env != nil {
+		cmd.Env = append(os.Environ(), r.Env...)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		r.Err = err
+		e.finish(r)
+		return
+	}
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	isolate(cmd)
+	if r.ctx.Err() != nil {
+		pw.Close()
+		pr.Close()
+		r.Err = context.Cause(r.ctx)
+		e.finish(r)
+		return
+	}
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		r.Err = fmt.Errorf("exec: %w", err)
+		e.finish(r)
+		return
+	}
+	r.Started = true
+	adopt(cmd)
+	e.mu.Lock()
+	r.cmd = cmd
+	e.mu.Unlock()
+	if e.Started != nil {
+		e.Started(r, cmd.Process.Pid)
+	}
+	if e.Timeout > 0 {
+		t := time.AfterFunc(e.Timeout, func() { r.cancel(ErrTimeout) })
+		defer t.Stop()
+	}
+	exited := make(chan bool)
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			e.logf("run %d %v: signaling pid %d", r.ID, context.Cause(r.ctx), cmd.Process.Pid)
+			kill(cmd)
+		case <-exited:
+		}
+	}()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := pr.Read(buf)
+		if err != nil {
+			break
+		}
+		p := append([]byte(nil), buf[:n]...)
+		e.Post(r, func() { e.Frontend.Output(r, p) })
+	}
+	pr.Close()
+	r.Err = cmd.Wait()
+	close(exited)
+	release(cmd)
+	if r.Err != nil && context.Cause(r.ctx) == ErrTimeout {
+		r.Err = fmt.Errorf("%w after %v: %v", ErrTimeout, e.Timeout, r.Err)
+	}
+	e.finish(r)
+}
+
+// finish hands the ended run r to the frontend. The run is only
+// marked as finished once the frontend is done with it, so that
+// anything the frontend writes to disk counts as the run's output.
+func (e *Engine) finish(r *Run) {
+	r.End = time.Now()
+	e.ops <- op{r: r, always: true, f: func() {
+		r.Superseded = r.superseded()
+		switch {
+		case r.begun:
+			e.protect("End", func() { e.Frontend.End(r) })
+		case e.Discard != nil:
+			e.protect("Discard", func() { e.Discard(r) })
+		}
+		e.mu.Lock()
+		var rerun []string
+		if e.cur == r {
+			e.end = time.Now()
+			e.busy = false
+			for name := range e.changed {
+				if !e.wrote[name] {
+					rerun = append(rerun, name)
+				}
+			}
+			e.wrote, e.changed = e.changed, nil
+		}
+		e.mu.Unlock()
+		settle()
+		r.cancel(nil)
+		if len(rerun) > 0 {
+			sort.Strings(rerun)
+			e.logf("run %d: %s changed during it but not during the run before", r.ID, strings.Join(rerun, " "))
+			e.rerun(r, Trigger{Kind: "file", File: rerun[0]})
+		}
+	}}
+}
+
+// An op is a call to be made from the frontend goroutine
+// on behalf of run r. A run is canceled as superseded before the
+// next run's Begin is queued, so dropping the ops of superseded runs
+// means a late write from an old run never reaches a newer run's
+// output. Ops with no run, or always set, are always made.
+type op struct {
+	r      *Run
+	always bool
+	f      func()
+}
+
+func (e *Engine) frontend() {
+	defer close(e.exited)
+	for {
+		select {
+		case op := <-e.ops:
+			e.do(op)
+		case <-e.done:
+			for {
+				select {
+				case op := <-e.ops:
+					e.do(op)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// do makes the call op, unless it is for a superseded run.
+func (e *Engine) do(op op) {
+	if op.r != nil && !op.always && op.r.superseded() {
+		return
+	}
+	e.protect("frontend", op.f)
+}
+
+// command calls e.Command, turning a panic into an error if e.Recover is set.
+func (e *Engine) command() (line string, err error) {
+	defer e.recoverTo("Command", &err)
+	return e.Command()
+}
+
+// prepare calls e.Prepare, turning a panic into an error if e.Recover is set.
+func (e *Engine) prepare(r *Run) (err error) {
+	defer e.recoverTo("Prepare", &err)
+	return e.Prepare(r)
+}
+
+// protect calls f, recovering from a panic if e.Recover is set.
+func (e *Engine) protect(where string, f func()) {
+	var err error
+	defer e.recoverTo(where, &err)
+	f()
+}
+
+// recoverTo, when deferred, recovers from a panic if e.Recover is set,
+// reporting it to e.Recover and setting *errp to describe it.
+func (e *Engine) recoverTo(where string, errp *error) {
+	if e.Recover == nil {
+		return
+	}
+	if v := recover(); v != nil {
+		e.Recover(where, v, debug.Stack())
+		*errp = fmt.Errorf("panic in %s: %v", where, v)
+	}
+}
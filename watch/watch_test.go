@@ -0,0 +1,517 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A logFrontend records the calls made to it.
+type logFrontend struct {
+	mu    sync.Mutex
+	log   []string
+	ended chan *Run
+}
+
+func (f *logFrontend) add(format string, args ...interface{}) {
+	f.mu.Lock()
+	f.log = append(f.log, fmt.Sprintf(format, args...))
+	f.mu.Unlock()
+}
+
+func (f *logFrontend) Begin(r *Run)            { f.add("begin %d", r.ID) }
+func (f *logFrontend) Output(r *Run, p []byte) { f.add("output %d %q", r.ID, p) }
+
+func (f *logFrontend) End(r *Run) {
+	f.add("end %d superseded=%v", r.ID, r.Superseded)
+	f.ended <- r
+}
+
+func (f *logFrontend) wait(t *testing.T) *Run {
+	t.Helper()
+	select {
+	case r := <-f.ended:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for run to end")
+		return nil
+	}
+}
+
+func newTestEngine(t *testing.T, lines ...string) (*Engine, *logFrontend) {
+	f := &logFrontend{ended: make(chan *Run, 100)}
+	var mu sync.Mutex
+	e := New(f, func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		line := lines[0]
+		if len(lines) > 1 {
+			lines = lines[1:]
+		}
+		return line, nil
+	})
+	e.Shell = "/bin/sh"
+	t.Cleanup(e.Stop)
+	return e, f
+}
+
+func TestSupersede(t *testing.T) {
+	e, f := newTestEngine(t, "echo one; sleep 10", "echo two")
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	time.Sleep(200 * time.Millisecond)
+	e.Kick(Trigger{Kind: "put"})
+	r1, r2 := f.wait(t), f.wait(t)
+	if r1.ID > r2.ID {
+		r1, r2 = r2, r1
+	}
+	if r1.ID != 1 || !r1.Superseded || r1.Err == nil || context.Cause(r1.Context()) != ErrSuperseded {
+		t.Errorf("first run: ID=%d Superseded=%v Err=%v, want killed and superseded", r1.ID, r1.Superseded, r1.Err)
+	}
+	if r2.ID != 2 || r2.Superseded || r2.Err != nil {
+		t.Errorf("second run: ID=%d Superseded=%v Err=%v, want clean exit", r2.ID, r2.Superseded, r2.Err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	begun := false
+	for _, l := range f.log {
+		if l == "begin 2" {
+			begun = true
+		}
+		if begun && len(l) > 8 && l[:8] == "output 1" {
+			t.Errorf("output from run 1 after run 2 began: %q", f.log)
+		}
+	}
+}
+
+func TestKill(t *testing.T) {
+	e, f := newTestEngine(t, "sleep 10")
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	e.Kill()
+	r := f.wait(t)
+	if r.Err == nil || r.Superseded || context.Cause(r.Context()) != ErrKilled {
+		t.Errorf("Err=%v Superseded=%v, want killed", r.Err, r.Superseded)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("kill took %v", d)
+	}
+}
+
+func TestKillGroup(t *testing.T) {
+	// The shell and its child ignore SIGINT, and the child holds
+	// the output open, so the run ends only once the whole
+	// process group has been forced to stop.
+	e, f := newTestEngine(t, "trap '' INT; sleep 10 & sleep 10")
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	e.Kill()
+	f.wait(t)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("kill took %v", d)
+	}
+}
+
+func TestStartError(t *testing.T) {
+	e, f := newTestEngine(t, "true")
+	e.Shell = "/nonexistent/shell"
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	if r := f.wait(t); r.Started || r.Err == nil {
+		t.Errorf("Started=%v Err=%v, want start failure", r.Started, r.Err)
+	}
+}
+
+func TestFileChangedIgnoresOwnOutput(t *testing.T) {
+	dir := t.TempDir()
+	gen := filepath.Join(dir, "gen.go")
+	e, f := newTestEngine(t, "sleep 0.2; echo generated >"+gen)
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	f.wait(t)
+	e.FileChanged(gen)
+	select {
+	case r := <-f.ended:
+		t.Fatalf("run %d triggered by the command's own output", r.ID)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := os.WriteFile(gen, []byte("edited"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	e.FileChanged(gen)
+	if r := f.wait(t); r.Trigger.Kind != "file" {
+		t.Errorf("Trigger = %+v, want file", r.Trigger)
+	}
+}
+
+func TestFileChangedDuringRun(t *testing.T) {
+	dir := t.TempDir()
+	gen := filepath.Join(dir, "gen.go")
+	src := filepath.Join(dir, "x.go")
+	e, f := newTestEngine(t, "echo generated >"+gen+"; sleep 0.5")
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	time.Sleep(200 * time.Millisecond)
+	e.FileChanged(gen)
+	if err := os.WriteFile(src, []byte("edited"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	e.FileChanged(src)
+	f.wait(t)
+
+	// The command writes gen.go each time, so the rerun's write
+	// is taken as its output.
+	time.Sleep(200 * time.Millisecond)
+	e.FileChanged(gen)
+	r := f.wait(t)
+	if r.Trigger.Kind != "file" {
+		t.Errorf("Trigger = %+v, want file", r.Trigger)
+	}
+	select {
+	case r := <-f.ended:
+		t.Fatalf("run %d triggered by the command's own output", r.ID)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// An edit made during a run still triggers one after it.
+	e.Kick(Trigger{Kind: "put"})
+	time.Sleep(200 * time.Millisecond)
+	e.FileChanged(gen)
+	e.FileChanged(src)
+	if r = f.wait(t); r.ID != 3 {
+		t.Fatalf("run %d ended, want 3", r.ID)
+	}
+	if r = f.wait(t); r.Trigger.Kind != "file" || r.Trigger.File != src {
+		t.Errorf("Trigger = %+v, want file %s", r.Trigger, src)
+	}
+}
+
+func TestFileChangedBeforeFirstRun(t *testing.T) {
+	// As with -norun: the first run is the one a change triggers.
+	src := filepath.Join(t.TempDir(), "x.go")
+	if err := os.WriteFile(src, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	e, f := newTestEngine(t, "true")
+	go e.Run()
+	e.FileChanged(src)
+	if r := f.wait(t); r.Trigger.Kind != "file" {
+		t.Errorf("Trigger = %+v, want file", r.Trigger)
+	}
+}
+
+func TestPollClockSkew(t *testing.T) {
+	// The file server's clock is ahead of ours for the run's own
+	// output and behind it for the edit that follows.
+	root := t.TempDir()
+	out := filepath.Join(root, "out")
+	src := filepath.Join(root, "x.go")
+	if err := os.WriteFile(src, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	e, f := newTestEngine(t, "echo generated >"+out+"; touch -t 203001010000 "+out+"; sleep 0.2")
+	if err := Poll(root, false, 20*time.Millisecond, e.FileChanged); err != nil {
+		t.Fatal(err)
+	}
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	f.wait(t)
+	// The first run's output is new to the engine and so is rerun once.
+	if r := f.wait(t); r.Trigger.File != out {
+		t.Fatalf("Trigger = %+v, want file %s", r.Trigger, out)
+	}
+	select {
+	case r := <-f.ended:
+		t.Fatalf("run %d triggered by the command's own output", r.ID)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(src, []byte("edited"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(src, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if r := f.wait(t); r.Trigger.Kind != "file" || r.Trigger.File != src {
+		t.Errorf("Trigger = %+v, want file %s", r.Trigger, src)
+	}
+}
+
+func TestKillBeforeStart(t *testing.T) {
+	e, f := newTestEngine(t, "true")
+	e.Prepare = func(r *Run) error {
+		e.Kill()
+		return nil
+	}
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	if r := f.wait(t); r.Started || r.Err != ErrKilled {
+		t.Errorf("Started=%v Err=%v, want not started, killed", r.Started, r.Err)
+	}
+}
+
+func TestStorm(t *testing.T) {
+	e, f := newTestEngine(t, "true")
+	e.StormRate = 10
+	e.StormQuiet = 200 * time.Millisecond
+	go e.Run()
+	for i := 0; i < 100; i++ {
+		e.Kick(Trigger{Kind: "file", File: fmt.Sprint(i)})
+		time.Sleep(time.Millisecond)
+	}
+	// Up to StormRate triggers may start runs before the storm
+	// is noticed; after that there should be just one more.
+	last := f.wait(t)
+	for done := false; !done; {
+		select {
+		case last = <-f.ended:
+		case <-time.After(time.Second):
+			done = true
+		}
+	}
+	if last.ID > e.StormRate+1 {
+		t.Errorf("storm caused %d runs", last.ID)
+	}
+}
+
+func TestFileStorm(t *testing.T) {
+	// As from a git checkout: a burst of changes during a run.
+	dir := t.TempDir()
+	e, f := newTestEngine(t, "ls "+dir+" | wc -l; sleep 1")
+	e.StormRate = 50
+	e.StormQuiet = 200 * time.Millisecond
+	if err := Files(dir, false, e.FileChanged); err != nil {
+		t.Fatal(err)
+	}
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	time.Sleep(200 * time.Millisecond)
+	for i := 0; i < 300; i++ {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%03d", i)), nil, 0666)
+	}
+	last := f.wait(t)
+	if !last.Superseded {
+		t.Errorf("run %d outlasted the storm", last.ID)
+	}
+	for done := false; !done; {
+		select {
+		case last = <-f.ended:
+		case <-time.After(2 * time.Second):
+			done = true
+		}
+	}
+	if last.ID != 2 || last.Superseded {
+		t.Errorf("last run: ID=%d Superseded=%v, want run 2, not superseded", last.ID, last.Superseded)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	want := fmt.Sprintf("output %d %q", last.ID, "300\n")
+	if !strings.Contains(strings.Join(f.log, "\n"), want) {
+		t.Errorf("log %q lacks %s", f.log, want)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	e, f := newTestEngine(t, "true")
+	e.Delay = 300 * time.Millisecond
+	go e.Run()
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		e.Kick(Trigger{Kind: "put"})
+		time.Sleep(100 * time.Millisecond)
+	}
+	r := f.wait(t)
+	if d := r.Start.Sub(start); d < 700*time.Millisecond {
+		t.Errorf("run started %v after the first trigger, want after the last plus the delay", d)
+	}
+	if r.ID != 1 {
+		t.Errorf("run ID = %d, want 1", r.ID)
+	}
+}
+
+func TestWalkSymlinkLoop(t *testing.T) {
+	defer func(b bool) { FollowSymlinks = b }(FollowSymlinks)
+	FollowSymlinks = true
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a", "b"), 0777)
+	os.WriteFile(filepath.Join(root, "a", "b", "f"), nil, 0666)
+	if err := os.Symlink("../..", filepath.Join(root, "a", "b", "up")); err != nil {
+		t.Skip(err)
+	}
+	os.Symlink("a", filepath.Join(root, "link"))
+	var files []string
+	if err := Walk(root, true, func(name string, info fs.FileInfo) {
+		rel, _ := filepath.Rel(root, name)
+		files = append(files, rel)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := "[a/b/f link/b/f]"
+	if fmt.Sprint(files) != want {
+		t.Errorf("Walk found %v, want %s", files, want)
+	}
+}
+
+func TestFilesLazy(t *testing.T) {
+	defer func(n int) { LazyDepth = n }(LazyDepth)
+	LazyDepth = 1
+	root := t.TempDir()
+	deep := filepath.Join(root, "a", "b", "c")
+	os.MkdirAll(deep, 0777)
+	changes := make(chan string, 10)
+	if err := Files(root, true, func(name string) { changes <- name }); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(deep, "f")
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; ; i++ {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(name, []byte(fmt.Sprint(i)), 0666)
+		select {
+		case got := <-changes:
+			if got != name {
+				t.Fatalf("changed %s, want %s", got, name)
+			}
+			return
+		case <-time.After(1500 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("deep directory never watched")
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	e, f := newTestEngine(t, "echo one")
+	var mu sync.Mutex
+	var recovered []string
+	e.Recover = func(where string, v interface{}, stack []byte) {
+		mu.Lock()
+		recovered = append(recovered, fmt.Sprintf("%s: %v", where, v))
+		mu.Unlock()
+	}
+	e.Prepare = func(r *Run) error {
+		if r.ID == 1 {
+			panic("bad prepare")
+		}
+		return nil
+	}
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	if r := f.wait(t); r.Started || r.Err == nil || r.Err.Error() != "panic in Prepare: bad prepare" {
+		t.Errorf("run 1: Started=%v Err=%v, want panic error", r.Started, r.Err)
+	}
+	e.Kick(Trigger{Kind: "put"})
+	if r := f.wait(t); r.Err != nil {
+		t.Errorf("run 2: %v", r.Err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(recovered) != 1 || recovered[0] != "Prepare: bad prepare" {
+		t.Errorf("recovered %q", recovered)
+	}
+}
+
+func TestSupersededBeforeBegin(t *testing.T) {
+	e, f := newTestEngine(t, "true")
+	release := make(chan bool)
+	prepared := make(chan int, 10)
+	discarded := make(chan int, 10)
+	e.Prepare = func(r *Run) error {
+		if r.ID == 2 {
+			// Hold up the frontend goroutine, so that run 2's
+			// Begin is still queued when run 3 supersedes it.
+			e.Post(nil, func() { <-release })
+		}
+		prepared <- r.ID
+		return nil
+	}
+	e.Discard = func(r *Run) { discarded <- r.ID }
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	<-prepared
+	f.wait(t)
+	for i := 2; i <= 3; i++ {
+		e.Kick(Trigger{Kind: "put"})
+		if id := <-prepared; id != i {
+			t.Fatalf("prepared run %d, want %d", id, i)
+		}
+	}
+	time.Sleep(50 * time.Millisecond) // let run 3 queue its Begin
+	close(release)
+	if r := f.wait(t); r.ID != 3 {
+		t.Fatalf("ended run %d, want 3", r.ID)
+	}
+	select {
+	case id := <-discarded:
+		if id != 2 {
+			t.Errorf("discarded run %d, want 2", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run 2 not discarded")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, l := range f.log {
+		if l == "begin 2" || strings.HasPrefix(l, "end 2 ") {
+			t.Errorf("frontend saw %q for a run superseded before it began: %q", l, f.log)
+		}
+	}
+}
+
+func TestStopBeforeRun(t *testing.T) {
+	e, _ := newTestEngine(t, "true")
+	e.Stop()
+	called := make(chan bool, 1)
+	e.Post(nil, func() { called <- true })
+	e.Run()
+	select {
+	case <-called:
+		t.Error("frontend called after Stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStop(t *testing.T) {
+	e, f := newTestEngine(t, "sleep 10")
+	go e.Run()
+	e.Kick(Trigger{Kind: "start"})
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	e.Stop()
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Stop took %v", d)
+	}
+	select {
+	case r := <-f.ended:
+		if context.Cause(r.Context()) != ErrKilled {
+			t.Errorf("run ended with %v, want killed", context.Cause(r.Context()))
+		}
+	default:
+		t.Fatal("Stop returned before the run ended")
+	}
+	e.Kick(Trigger{Kind: "put"})
+	e.Post(nil, func() { t.Error("call made after Stop") })
+	select {
+	case r := <-f.ended:
+		t.Errorf("run %d after Stop", r.ID)
+	case <-time.After(300 * time.Millisecond):
+	}
+}